@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/nilszeilon/notesync/internal/markdown"
 	"github.com/nilszeilon/notesync/internal/sync"
 )
 
@@ -15,6 +16,7 @@ func main() {
 	publishServer := flag.String("publish-server", "", "publish server URL (syncs published files only)")
 	pushOnly := flag.Bool("push-only", false, "only push local files, don't download new remote files (still syncs updates to existing local files)")
 	poll := flag.Duration("poll", 30*time.Second, "interval to poll remote for changes from other clients (0 to disable)")
+	attachments := flag.String("attachments", "", "Obsidian attachment folder, used to find the images published notes embed (default: the vault's setting in .obsidian/app.json)")
 	flag.Parse()
 
 	if *server == "" && *publishServer == "" {
@@ -33,7 +35,11 @@ func main() {
 		publishClient = sync.NewClient(*publishServer, publishToken)
 	}
 
-	watcher := sync.NewWatcher(*dir, client, publishClient, *pushOnly, *poll)
+	if *attachments == "" {
+		*attachments = markdown.AttachmentFolder(*dir)
+	}
+
+	watcher := sync.NewWatcher(*dir, client, publishClient, *pushOnly, *poll, *attachments)
 
 	// Full sync on startup
 	log.Println("performing full sync...")
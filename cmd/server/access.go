@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nilszeilon/notesync/internal/site"
+)
+
+// accessKeyFile holds the key login cookies are signed with, in the site
+// directory so logins survive restarts. Deleting it logs everyone out.
+const accessKeyFile = ".access-key"
+
+// loginMaxAge is how long a login lasts.
+const loginMaxAge = 30 * 24 * time.Hour
+
+// user is an entry in the access file.
+type user struct {
+	password string
+	groups   []string
+}
+
+// loadUsers reads an access file: one user per line, written as
+// name:password:group1,group2. Blank lines and lines starting with # are
+// skipped.
+func loadUsers(file string) (map[string]user, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]user)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s:%d: want name:password:groups", file, line)
+		}
+		u := user{password: parts[1]}
+		for _, g := range strings.Split(parts[2], ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				u.groups = append(u.groups, g)
+			}
+		}
+		users[parts[0]] = u
+	}
+	return users, scanner.Err()
+}
+
+// gate serves the site's protected files only to their readers: visitors
+// who send the password or a group member's name and password with HTTP
+// basic auth, or who logged in with the form it shows everyone else.
+type gate struct {
+	builder *site.Builder
+	users   map[string]user
+	key     []byte
+}
+
+func (g *gate) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		access, ok := g.builder.Access(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		if g.allowed(r, access) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if g.login(w, r, access) {
+				http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+				return
+			}
+			time.Sleep(time.Second) // slows down password guessing
+			g.loginForm(w, access, true)
+			return
+		}
+		g.loginForm(w, access, false)
+	})
+}
+
+// allowed reports whether r comes from a reader of access.
+func (g *gate) allowed(r *http.Request, access site.Access) bool {
+	if name, password, ok := r.BasicAuth(); ok && g.check(access, name, password) {
+		return true
+	}
+	for _, p := range access.Passwords {
+		if c, err := r.Cookie(g.passwordCookie(p)); err == nil && g.verify(c.Value, "password|"+p) {
+			return true
+		}
+	}
+	if c, err := r.Cookie("notesync_user"); err == nil {
+		encoded, value, _ := strings.Cut(c.Value, ".")
+		name, err := base64.RawURLEncoding.DecodeString(encoded)
+		if u, ok := g.users[string(name)]; err == nil && ok && g.verify(value, "user|"+string(name)+"|"+u.password) {
+			return inGroups(u, access)
+		}
+	}
+	return false
+}
+
+// check reports whether password, or the user name and password, give
+// access.
+func (g *gate) check(access site.Access, name, password string) bool {
+	for _, p := range access.Passwords {
+		if equal(p, password) {
+			return true
+		}
+	}
+	u, ok := g.users[name]
+	return ok && equal(u.password, password) && inGroups(u, access)
+}
+
+// login checks the login form posted in r and, if it gives access, sets
+// the cookie that keeps the visitor logged in.
+func (g *gate) login(w http.ResponseWriter, r *http.Request, access site.Access) bool {
+	name, password := r.PostFormValue("user"), r.PostFormValue("password")
+	expires := time.Now().Add(loginMaxAge)
+	for _, p := range access.Passwords {
+		if equal(p, password) {
+			g.setCookie(w, r, g.passwordCookie(p), g.sign("password|"+p, expires), expires)
+			return true
+		}
+	}
+	u, ok := g.users[name]
+	if !ok || !equal(u.password, password) || !inGroups(u, access) {
+		return false
+	}
+	value := base64.RawURLEncoding.EncodeToString([]byte(name)) + "." + g.sign("user|"+name+"|"+u.password, expires)
+	g.setCookie(w, r, "notesync_user", value, expires)
+	return true
+}
+
+func (g *gate) setCookie(w http.ResponseWriter, r *http.Request, name, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// passwordCookie names the cookie of a login with password p. The name
+// doesn't give the password away, and changes with it.
+func (g *gate) passwordCookie(p string) string {
+	return "notesync_pw_" + g.mac("cookie|" + p)[:16]
+}
+
+// sign returns a cookie value proving a login as subject that is valid
+// until expires.
+func (g *gate) sign(subject string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + g.mac(subject+"|"+exp)
+}
+
+// verify reports whether value is an unexpired signature for subject.
+func (g *gate) verify(value, subject string) bool {
+	exp, mac, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(g.mac(subject+"|"+exp)))
+}
+
+func (g *gate) mac(s string) string {
+	h := hmac.New(sha256.New, g.key)
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func inGroups(u user, access site.Access) bool {
+	for _, group := range u.groups {
+		if slices.Contains(access.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+var loginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<meta name="robots" content="noindex">
+	<title>Log in</title>
+	<style>
+		body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; color: #202122; max-width: 22rem; margin: 4rem auto; padding: 0 1rem; line-height: 1.5; }
+		h1 { font-size: 1.3rem; font-weight: normal; border-bottom: 1px solid #a2a9b1; }
+		label { display: block; margin: 0.8rem 0; }
+		input { display: block; width: 100%; box-sizing: border-box; padding: 0.4rem; font: inherit; border: 1px solid #a2a9b1; }
+		button { padding: 0.4rem 1rem; font: inherit; color: #fff; background: #3366cc; border: 0; cursor: pointer; }
+		.error { color: #d33; }
+	</style>
+</head>
+<body>
+	<form method="post">
+		<h1>This page is protected</h1>
+		{{if .Failed}}<p class="error">Wrong {{if .Groups}}user name or {{end}}password.</p>{{end}}
+		{{if .Groups}}<label>User name <input name="user" autocomplete="username"></label>{{end}}
+		<label>Password <input type="password" name="password" autocomplete="current-password" required autofocus></label>
+		<button>Log in</button>
+	</form>
+</body>
+</html>
+`))
+
+func (g *gate) loginForm(w http.ResponseWriter, access site.Access, failed bool) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	loginTmpl.Execute(w, struct {
+		Groups bool
+		Failed bool
+	}{len(access.Groups) > 0, failed})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilszeilon/notesync/internal/site"
+)
+
+func testGate() *gate {
+	return &gate{
+		users: map[string]user{
+			"alice": {password: "wonder", groups: []string{"colleagues"}},
+			"bob":   {password: "builder", groups: []string{"family"}},
+		},
+		key: []byte("test key"),
+	}
+}
+
+func TestGateAllowed(t *testing.T) {
+	g := testGate()
+	byPassword := site.Access{Passwords: []string{"hunter2"}}
+	byGroup := site.Access{Groups: []string{"colleagues"}}
+	expired := time.Now().Add(-time.Minute)
+	valid := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		access site.Access
+		req    func(r *http.Request)
+		want   bool
+	}{
+		{"no credentials", byPassword, func(r *http.Request) {}, false},
+		{"basic auth with the password", byPassword, func(r *http.Request) { r.SetBasicAuth("anyone", "hunter2") }, true},
+		{"basic auth with a wrong password", byPassword, func(r *http.Request) { r.SetBasicAuth("anyone", "hunter3") }, false},
+		{"basic auth as a group member", byGroup, func(r *http.Request) { r.SetBasicAuth("alice", "wonder") }, true},
+		{"basic auth as another group's member", byGroup, func(r *http.Request) { r.SetBasicAuth("bob", "builder") }, false},
+		{"basic auth with a user's wrong password", byGroup, func(r *http.Request) { r.SetBasicAuth("alice", "builder") }, false},
+		{"password cookie", byPassword, func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: g.passwordCookie("hunter2"), Value: g.sign("password|hunter2", valid)})
+		}, true},
+		{"expired password cookie", byPassword, func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: g.passwordCookie("hunter2"), Value: g.sign("password|hunter2", expired)})
+		}, false},
+		{"forged password cookie", byPassword, func(r *http.Request) {
+			value := g.sign("password|hunter2", valid)
+			r.AddCookie(&http.Cookie{Name: g.passwordCookie("hunter2"), Value: value[:len(value)-1] + "0"})
+		}, false},
+		{"cookie for an old password", byPassword, func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: g.passwordCookie("hunter1"), Value: g.sign("password|hunter1", valid)})
+		}, false},
+		{"user cookie", byGroup, func(r *http.Request) { r.AddCookie(userCookie(g, "alice", "wonder", valid)) }, true},
+		{"expired user cookie", byGroup, func(r *http.Request) { r.AddCookie(userCookie(g, "alice", "wonder", expired)) }, false},
+		{"user cookie from before a password change", byGroup, func(r *http.Request) { r.AddCookie(userCookie(g, "alice", "old", valid)) }, false},
+		{"user cookie of another group", byGroup, func(r *http.Request) { r.AddCookie(userCookie(g, "bob", "builder", valid)) }, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/secret/", nil)
+		tt.req(r)
+		if got := g.allowed(r, tt.access); got != tt.want {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func userCookie(g *gate, name, password string, expires time.Time) *http.Cookie {
+	value := base64.RawURLEncoding.EncodeToString([]byte(name)) + "." + g.sign("user|"+name+"|"+password, expires)
+	return &http.Cookie{Name: "notesync_user", Value: value}
+}
+
+func TestGateLogin(t *testing.T) {
+	g := testGate()
+	access := site.Access{Passwords: []string{"hunter2"}, Groups: []string{"colleagues"}}
+
+	tests := []struct {
+		name string
+		form url.Values
+		want bool
+	}{
+		{"password", url.Values{"password": {"hunter2"}}, true},
+		{"group member", url.Values{"user": {"alice"}, "password": {"wonder"}}, true},
+		{"wrong password", url.Values{"password": {"wonder"}}, false},
+		{"other group", url.Values{"user": {"bob"}, "password": {"builder"}}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/secret/", strings.NewReader(tt.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		if got := g.login(w, r, access); got != tt.want {
+			t.Errorf("%s: login = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		cookies := w.Result().Cookies()
+		if !tt.want {
+			if len(cookies) > 0 {
+				t.Errorf("%s: failed login set cookies", tt.name)
+			}
+			continue
+		}
+		// The cookie set by the login lets the visitor in afterwards.
+		next := httptest.NewRequest(http.MethodGet, "/secret/", nil)
+		for _, c := range cookies {
+			next.AddCookie(c)
+		}
+		if !g.allowed(next, access) {
+			t.Errorf("%s: cookie set by login not accepted", tt.name)
+		}
+	}
+}
+
+// A restarted server protects the live build as its access file says before
+// its first build. Without one, the build is from before protection; if it
+// can't be read, an earlier build's is used, and failing that everything is
+// denied rather than serving protected pages to anyone.
+func TestGateAfterRestart(t *testing.T) {
+	const secret = `{"/secret":{"Passwords":["hunter2"]}}`
+	tests := []struct {
+		name    string
+		files   map[string]string // under builds/
+		live    string
+		allowed map[string]bool // path -> whether it is served
+	}{
+		{"no build", nil, "", map[string]bool{"/": false}},
+		{"access file", map[string]string{"1.access.json": secret}, "1", map[string]bool{"/": true, "/secret/": false}},
+		{"build from before protection", nil, "1", map[string]bool{"/": true, "/secret/": true}},
+		{"unreadable access file", map[string]string{"1.access.json": secret, "2.access.json": "{"}, "2", map[string]bool{"/": true, "/secret/": false}},
+		{"no readable access file", map[string]string{"2.access.json": "{"}, "2", map[string]bool{"/": false}},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(t.TempDir(), "site")
+		os.MkdirAll(filepath.Join(out, "builds"), 0755)
+		for name, data := range tt.files {
+			os.WriteFile(filepath.Join(out, "builds", name), []byte(data), 0644)
+		}
+		if tt.live != "" {
+			os.Mkdir(filepath.Join(out, "builds", tt.live), 0755)
+			os.Symlink(filepath.Join("builds", tt.live), filepath.Join(out, "current"))
+		}
+
+		g := testGate()
+		g.builder = site.NewBuilder(t.TempDir(), out, site.Config{})
+		for p, want := range tt.allowed {
+			served := false
+			h := g.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served = true }))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+			if served != want || !want && w.Code != http.StatusUnauthorized {
+				t.Errorf("%s: %s got %d, served %v; want served %v", tt.name, p, w.Code, served, want)
+			}
+		}
+	}
+}
+
+func TestLoadUsers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users")
+	os.WriteFile(file, []byte("# readers\nalice:wonder:colleagues, family\n\nbob:pass:word:\n"), 0644)
+	users, err := loadUsers(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := users["alice"]; u.password != "wonder" || strings.Join(u.groups, ",") != "colleagues,family" {
+		t.Errorf("alice = %+v", u)
+	}
+	if u := users["bob"]; u.password != "pass" || strings.Join(u.groups, ",") != "word:" {
+		t.Errorf("bob = %+v", u)
+	}
+
+	os.WriteFile(file, []byte("alice:wonder\n"), 0644)
+	if _, err := loadUsers(file); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("loadUsers with a malformed line: err = %v", err)
+	}
+}
@@ -2,14 +2,22 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	notesync "github.com/nilszeilon/notesync"
 	"github.com/nilszeilon/notesync/internal/api"
+	"github.com/nilszeilon/notesync/internal/fileutil"
+	"github.com/nilszeilon/notesync/internal/search"
 	"github.com/nilszeilon/notesync/internal/site"
 	"github.com/nilszeilon/notesync/internal/storage"
 )
@@ -18,8 +26,42 @@ func main() {
 	port := flag.String("port", "8080", "server port")
 	dataDir := flag.String("data", "./data", "data directory for stored files")
 	siteDir := flag.String("site", "./_site", "output directory for generated site")
+	baseURL := flag.String("base-url", os.Getenv("NOTESYNC_BASE_URL"), "public URL of the site, used for absolute links in feeds (env NOTESYNC_BASE_URL)")
+	siteTitle := flag.String("site-title", os.Getenv("NOTESYNC_SITE_TITLE"), "site title (env NOTESYNC_SITE_TITLE)")
+	siteAuthor := flag.String("site-author", os.Getenv("NOTESYNC_SITE_AUTHOR"), "site author (env NOTESYNC_SITE_AUTHOR)")
+	siteLanguage := flag.String("site-language", os.Getenv("NOTESYNC_SITE_LANGUAGE"), "language of the notes, e.g. \"en\" or \"sv\" (env NOTESYNC_SITE_LANGUAGE)")
+	robotsFile := flag.String("robots", os.Getenv("NOTESYNC_ROBOTS"), "file to publish as /robots.txt instead of the default, which allows all crawlers (env NOTESYNC_ROBOTS)")
+	rebuildQuiet := flag.Duration("rebuild-quiet", 2*time.Second, "wait for this long without file changes before rebuilding the site")
+	rebuildMaxDelay := flag.Duration("rebuild-max-delay", 30*time.Second, "rebuild at most this long after the first change, even if changes keep arriving")
+	strict := flag.Bool("strict", os.Getenv("NOTESYNC_STRICT") == "true", "fail site builds with broken links, missing images or duplicate URLs, keeping the last good build live (env NOTESYNC_STRICT)")
+	math := flag.Bool("math", os.Getenv("NOTESYNC_MATH") != "false", "render $...$ and $$...$$ LaTeX as MathML; notes can override with math: true/false (env NOTESYNC_MATH)")
+	footnotes := flag.Bool("footnotes", os.Getenv("NOTESYNC_FOOTNOTES") != "false", "render [^1] footnotes; notes can override with footnotes: true/false (env NOTESYNC_FOOTNOTES)")
+	sidenotes := flag.Bool("sidenotes", os.Getenv("NOTESYNC_SIDENOTES") == "true", "also show footnotes in the page margin on wide screens; notes can override with sidenotes: true/false (env NOTESYNC_SIDENOTES)")
+	definitionLists := flag.Bool("definition-lists", os.Getenv("NOTESYNC_DEFINITION_LISTS") != "false", "render definition lists; notes can override with definition_lists: true/false (env NOTESYNC_DEFINITION_LISTS)")
+	typographer := flag.Bool("typographer", os.Getenv("NOTESYNC_TYPOGRAPHER") != "false", "turn straight quotes, -- and ... into typographic ones; notes can override with typographer: true/false (env NOTESYNC_TYPOGRAPHER)")
+	emoji := flag.Bool("emoji", os.Getenv("NOTESYNC_EMOJI") != "false", "render :emoji: shortcodes; notes can override with emoji: true/false (env NOTESYNC_EMOJI)")
+	attachments := flag.String("attachments", os.Getenv("NOTESYNC_ATTACHMENTS"), "Obsidian attachment folder, used to find embedded images (default: the vault's setting in .obsidian/app.json) (env NOTESYNC_ATTACHMENTS)")
+	imageWidths := flag.String("image-widths", os.Getenv("NOTESYNC_IMAGE_WIDTHS"), "comma-separated widths in pixels of the resized copies published for each image, or \"none\"; defaults to 480,960,1600 (env NOTESYNC_IMAGE_WIDTHS)")
+	accessFile := flag.String("access-file", os.Getenv("NOTESYNC_ACCESS_FILE"), "file of users who may read notes with access: <group>, one per line as name:password:group1,group2 (env NOTESYNC_ACCESS_FILE)")
 	flag.Parse()
 
+	widths, err := parseWidths(*imageWidths)
+	if err != nil {
+		log.Fatalf("-image-widths: %v", err)
+	}
+	var users map[string]user
+	if *accessFile != "" {
+		if users, err = loadUsers(*accessFile); err != nil {
+			log.Fatalf("-access-file: %v", err)
+		}
+	}
+	var robots []byte
+	if *robotsFile != "" {
+		if robots, err = os.ReadFile(*robotsFile); err != nil {
+			log.Fatalf("-robots: %v", err)
+		}
+	}
+
 	// Load embedded templates
 	templateSub, err := fs.Sub(notesync.TemplateFS, "templates")
 	if err != nil {
@@ -44,22 +86,60 @@ func main() {
 	// Initialize site builder
 	absDataDir, _ := filepath.Abs(*dataDir)
 	absSiteDir, _ := filepath.Abs(*siteDir)
-	builder := site.NewBuilder(absDataDir, absSiteDir)
+	builder := site.NewBuilder(absDataDir, absSiteDir, site.Config{
+		BaseURL: *baseURL,
+		Title:   *siteTitle,
+		Author:  *siteAuthor,
+
+		Language: *siteLanguage,
+		Robots:   string(robots),
+
+		FailOnErrors: *strict,
+
+		DisableMath:            !*math,
+		DisableFootnotes:       !*footnotes,
+		Sidenotes:              *sidenotes,
+		DisableDefinitionLists: !*definitionLists,
+		DisableTypographer:     !*typographer,
+		DisableEmoji:           !*emoji,
+
+		ImageWidths:      widths,
+		AttachmentFolder: *attachments,
+	})
+	if *baseURL == "" {
+		log.Println("warning: -base-url not set, feeds will not be generated")
+	}
 
 	// Initial site build
 	if err := builder.Build(); err != nil {
 		log.Printf("initial site build: %v", err)
 	}
 
+	// Background rebuilds, coalesced across bursts of uploads
+	rebuilds := site.NewScheduler(builder, *rebuildQuiet, *rebuildMaxDelay)
+	rebuilds.Start()
+
 	// Set up HTTP routes
 	mux := http.NewServeMux()
 
 	// API routes
-	handler := api.NewHandler(store, builder, token)
+	handler := api.NewHandler(store, builder, rebuilds, search.NewIndex(), token)
 	handler.RegisterRoutes(mux)
 
-	// Static site serving
-	mux.Handle("/", http.FileServer(http.Dir(absSiteDir)))
+	// Search index over the whole vault, kept current by the file API
+	if err := handler.Reindex(); err != nil {
+		log.Printf("search index: %v", err)
+	}
+
+	// Static site serving. builder.LiveDir() is a symlink that each build
+	// atomically repoints at a complete output directory. Protected notes
+	// are served only to their readers.
+	accessKey, err := fileutil.LoadKey(filepath.Join(absSiteDir, accessKeyFile))
+	if err != nil {
+		log.Fatalf("access key: %v", err)
+	}
+	protected := &gate{builder: builder, users: users, key: accessKey}
+	mux.Handle("/", redirectMoved(builder, hideDrafts(protected.handler(http.FileServer(unlistedFS{http.Dir(builder.LiveDir())})))))
 
 	addr := ":" + *port
 	log.Printf("server starting on %s", addr)
@@ -70,3 +150,76 @@ func main() {
 		log.Fatalf("server error: %v", err)
 	}
 }
+
+// parseWidths parses a comma-separated list of image widths. An empty list
+// selects the defaults and "none" disables resizing.
+func parseWidths(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "":
+		return nil, nil
+	case "none":
+		return []int{}, nil
+	}
+	var widths []int
+	for _, f := range strings.Split(s, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid width %q", f)
+		}
+		widths = append(widths, w)
+	}
+	sort.Ints(widths)
+	return widths, nil
+}
+
+// redirectMoved answers requests for the old URLs of moved notes with a
+// permanent redirect to where they are now.
+func redirectMoved(builder *site.Builder, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to, ok := builder.Redirect(r.URL.EscapedPath()); ok {
+			http.Redirect(w, r, to, http.StatusMovedPermanently)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hideDrafts asks search engines not to index draft previews, including
+// their images and other files that can't carry a robots meta tag.
+func hideDrafts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if site.IsDraftPath(r.URL.Path) {
+			w.Header().Set("X-Robots-Tag", "noindex")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unlistedFS refuses to list folders under drafts/, whose names are the
+// secret tokens of the previews, and under images/, so only the images a
+// page shows can be found.
+type unlistedFS struct {
+	http.FileSystem
+}
+
+func (fsys unlistedFS) Open(name string) (http.File, error) {
+	f, err := fsys.FileSystem.Open(name)
+	if err != nil || !site.IsDraftPath(name) && !isImagePath(name) {
+		return f, err
+	}
+	if st, err := f.Stat(); err == nil && st.IsDir() {
+		index, err := fsys.FileSystem.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, fs.ErrNotExist
+		}
+		index.Close()
+	}
+	return f, nil
+}
+
+func isImagePath(name string) bool {
+	p := path.Clean("/" + name)
+	return p == "/images" || strings.HasPrefix(p, "/images/")
+}
@@ -1,39 +1,81 @@
 package api
 
 import (
+	"bytes"
 	"crypto/subtle"
 	"encoding/json"
 	"io"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 
+	"github.com/nilszeilon/notesync/internal/search"
 	"github.com/nilszeilon/notesync/internal/site"
 	"github.com/nilszeilon/notesync/internal/storage"
 )
 
 type Handler struct {
-	store   *storage.Storage
-	builder *site.Builder
-	token   string
+	store    *storage.Storage
+	builder  *site.Builder
+	rebuilds *site.Scheduler
+	index    *search.Index
+	token    string
 }
 
-func NewHandler(store *storage.Storage, builder *site.Builder, token string) *Handler {
-	return &Handler{store: store, builder: builder, token: token}
+func NewHandler(store *storage.Storage, builder *site.Builder, rebuilds *site.Scheduler, index *search.Index, token string) *Handler {
+	return &Handler{store: store, builder: builder, rebuilds: rebuilds, index: index, token: token}
 }
 
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/files/", h.authMiddleware(h.handleFiles))
 	mux.HandleFunc("/api/files", h.authMiddleware(h.handleListFiles))
 	mux.HandleFunc("/api/tombstones", h.authMiddleware(h.handleListTombstones))
+	mux.HandleFunc("/api/build", h.authMiddleware(h.handleBuildStatus))
+	mux.HandleFunc("/api/search", h.authMiddleware(h.handleSearch))
+	mux.HandleFunc("/api/report", h.authMiddleware(h.handleReport))
+	mux.HandleFunc("/api/report.html", h.browserAuth(h.handleReportHTML))
+}
+
+// Reindex loads every markdown file in storage into the search index.
+func (h *Handler) Reindex() error {
+	files, err := h.store.List()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if isNote(f.Path) {
+			h.indexFile(f.Path)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) indexFile(filePath string) {
+	rc, err := h.store.Get(filePath)
+	if err != nil {
+		log.Printf("search index %s: %v", filePath, err)
+		return
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		log.Printf("search index %s: %v", filePath, err)
+		return
+	}
+	h.index.Update(filePath, content)
+}
+
+func isNote(filePath string) bool {
+	return strings.EqualFold(path.Ext(filePath), ".md")
 }
 
 func (h *Handler) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if h.token != "" {
 			auth := r.Header.Get("Authorization")
-			if !strings.HasPrefix(auth, "Bearer ") ||
-				subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(h.token)) != 1 {
+			if !strings.HasPrefix(auth, "Bearer ") || !h.validToken(auth[7:]) {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
@@ -42,6 +84,28 @@ func (h *Handler) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// browserAuth is authMiddleware for pages opened in a browser: it also
+// accepts the token as the password of HTTP basic auth, with any user name,
+// and asks the browser for it.
+func (h *Handler) browserAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); ok && h.validToken(password) {
+			next(w, r)
+			return
+		}
+		if h.token != "" && !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			w.Header().Set("WWW-Authenticate", `Basic realm="notesync", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.authMiddleware(next)(w, r)
+	}
+}
+
+func (h *Handler) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
 func (h *Handler) handleListFiles(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -84,7 +148,11 @@ func (h *Handler) handleFiles(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		h.store.RemoveTombstone(filePath)
-		h.rebuild()
+		if isNote(filePath) {
+			h.indexFile(filePath)
+		}
+		h.builder.Changed(filePath)
+		h.rebuilds.Trigger()
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 
@@ -94,7 +162,9 @@ func (h *Handler) handleFiles(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		h.store.AddTombstone(filePath)
-		h.rebuild()
+		h.index.Remove(filePath)
+		h.builder.Changed(filePath)
+		h.rebuilds.Trigger()
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 
@@ -119,10 +189,71 @@ func (h *Handler) handleListTombstones(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(tombstones)
 }
 
-func (h *Handler) rebuild() {
-	if err := h.builder.Build(); err != nil {
-		log.Printf("site build error: %v", err)
-	} else {
-		log.Println("site rebuilt successfully")
+func (h *Handler) handleBuildStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.rebuilds.Status())
+}
+
+func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "q required", http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 100)
+	}
+
+	results := h.index.Search(q, limit)
+	if results == nil {
+		results = []search.Result{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func (h *Handler) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := h.builder.Report()
+	if report == nil {
+		http.Error(w, "no build report yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *Handler) handleReportHTML(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.builder.WriteReportHTML(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
 }
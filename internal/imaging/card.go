@@ -0,0 +1,157 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The size of social preview cards: the 1.91:1 ratio link previews are
+// shown at, at the resolution most services ask for.
+const (
+	CardWidth  = 1200
+	CardHeight = 630
+)
+
+const (
+	cardMargin     = 96
+	cardTitleLines = 4
+)
+
+var (
+	cardBackground = color.RGBA{0xf8, 0xf9, 0xfa, 0xff}
+	cardAccent     = color.RGBA{0x33, 0x66, 0xcc, 0xff}
+	cardRule       = color.RGBA{0xa2, 0xa9, 0xb1, 0xff}
+	cardText       = color.RGBA{0x20, 0x21, 0x22, 0xff}
+	cardMuted      = color.RGBA{0x54, 0x59, 0x5d, 0xff}
+
+	boldFont    = mustParseFont(gobold.TTF)
+	regularFont = mustParseFont(goregular.TTF)
+)
+
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Card draws a social preview card showing title in large type and site
+// below it. bg, if not nil, is scaled and cropped to fill the card;
+// otherwise the card gets a plain background in the default theme's colors.
+func Card(bg image.Image, title, site string) (*image.RGBA, error) {
+	card := image.NewRGBA(image.Rect(0, 0, CardWidth, CardHeight))
+	if bg != nil {
+		fill(card, bg)
+	} else {
+		draw.Draw(card, card.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+		draw.Draw(card, image.Rect(0, 0, 16, CardHeight), image.NewUniform(cardAccent), image.Point{}, draw.Src)
+		draw.Draw(card, image.Rect(cardMargin, CardHeight-cardMargin-2, CardWidth-cardMargin, CardHeight-cardMargin), image.NewUniform(cardRule), image.Point{}, draw.Src)
+	}
+
+	// Use the largest size at which the title fits.
+	width := CardWidth - 2*cardMargin
+	var face font.Face
+	var lines []string
+	for _, size := range []float64{80, 68, 56} {
+		var err error
+		face, err = opentype.NewFace(boldFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		lines = wrap(face, title, width)
+		if len(lines) <= cardTitleLines {
+			break
+		}
+	}
+	if len(lines) > cardTitleLines {
+		lines = lines[:cardTitleLines]
+		lines[cardTitleLines-1] = ellipsize(face, lines[cardTitleLines-1]+" …", width)
+	}
+	lineHeight := face.Metrics().Height.Mul(fixed.I(6)) / 5
+	dot := fixed.P(cardMargin, cardMargin).Add(fixed.Point26_6{Y: face.Metrics().Ascent})
+	for _, line := range lines {
+		d := font.Drawer{Dst: card, Src: image.NewUniform(cardText), Face: face, Dot: dot}
+		d.DrawString(line)
+		dot.Y += lineHeight
+	}
+
+	small, err := opentype.NewFace(regularFont, &opentype.FaceOptions{Size: 36, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	d := font.Drawer{Dst: card, Src: image.NewUniform(cardMuted), Face: small, Dot: fixed.P(cardMargin, CardHeight-cardMargin+56)}
+	d.DrawString(ellipsize(small, site, width))
+	return card, nil
+}
+
+// fill draws src over dst, scaled to cover it and cropped to its center.
+func fill(dst *image.RGBA, src image.Image) {
+	sb := src.Bounds()
+	db := dst.Bounds()
+	crop := sb
+	if sb.Dx()*db.Dy() > sb.Dy()*db.Dx() {
+		w := sb.Dy() * db.Dx() / db.Dy()
+		crop.Min.X += (sb.Dx() - w) / 2
+		crop.Max.X = crop.Min.X + w
+	} else {
+		h := sb.Dx() * db.Dy() / db.Dx()
+		crop.Min.Y += (sb.Dy() - h) / 2
+		crop.Max.Y = crop.Min.Y + h
+	}
+	xdraw.CatmullRom.Scale(dst, db, src, crop, xdraw.Src, nil)
+}
+
+// wrap breaks s into lines no wider than width, between words where it can.
+func wrap(face font.Face, s string, width int) []string {
+	max := fixed.I(width)
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && font.MeasureString(face, line+" "+word) <= max {
+			line += " " + word
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		line = word
+		// Break words too long for a line of their own.
+		for font.MeasureString(face, line) > max {
+			runes := []rune(line)
+			n := len(runes) - 1
+			for n > 1 && font.MeasureString(face, string(runes[:n])) > max {
+				n--
+			}
+			lines = append(lines, string(runes[:n]))
+			line = string(runes[n:])
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// ellipsize shortens s to fit in width, ending it with an ellipsis if it
+// had to be cut.
+func ellipsize(face font.Face, s string, width int) string {
+	max := fixed.I(width)
+	if font.MeasureString(face, s) <= max {
+		return s
+	}
+	runes := []rune(strings.TrimSuffix(s, " …"))
+	for len(runes) > 0 && font.MeasureString(face, strings.TrimSpace(string(runes))+"…") > max {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "…"
+}
@@ -0,0 +1,227 @@
+// Package imaging prepares photos and screenshots for publishing: it strips
+// camera metadata (EXIF, including GPS positions, XMP and IPTC) from JPEG, PNG
+// and WebP files without re-encoding them, and decodes, rotates and resizes
+// JPEG and PNG images for responsive variants.
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image")
+
+// Strip returns data with its metadata removed. format is "jpeg", "png" or
+// "webp"; data in any other format is returned unchanged. Pixel data is
+// copied as is, so the result looks exactly like the original.
+func Strip(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return stripJPEG(data)
+	case "png":
+		return stripPNG(data)
+	case "webp":
+		return stripWebP(data)
+	}
+	return data, nil
+}
+
+// JPEG markers.
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP14 = 0xEE
+	markerCOM   = 0xFE
+)
+
+// stripJPEG drops the APPn segments other than JFIF, ICC profiles and
+// Adobe's colour transform, drops comments, and cuts anything after the end
+// of the image, where phones append previews that carry their own EXIF.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, errMalformed
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	i := 2
+	for i+1 < len(data) {
+		if data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			i++ // fill byte
+			continue
+		case marker == markerEOI:
+			return append(out, data[i:i+2]...), nil
+		case marker >= 0xD0 && marker <= 0xD7 || marker == 0x01:
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, errMalformed
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return nil, errMalformed
+		}
+		if keepJPEGSegment(marker, data[i+4:end]) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if marker == markerSOS {
+			// Entropy-coded data runs up to the next marker; 0xFF bytes
+			// in it are followed by 0x00 or a restart marker.
+			start := i
+			for i+1 < len(data) && !(data[i] == 0xFF && data[i+1] != 0 && (data[i+1] < 0xD0 || data[i+1] > 0xD7)) {
+				i++
+			}
+			out = append(out, data[start:i]...)
+		}
+	}
+	return nil, errMalformed
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == markerAPP0 || marker == markerAPP14:
+		return true
+	case marker == markerAPP2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker > markerAPP0 && marker <= 0xEF, marker == markerCOM:
+		return false
+	}
+	return true
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the PNG chunks dropped by stripPNG.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformed
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end < i+12 || end > len(data) {
+			return nil, errMalformed
+		}
+		typ := string(data[i+4 : i+8])
+		if !pngMetadataChunks[typ] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if typ == "IEND" {
+			return out, nil
+		}
+	}
+	return nil, errMalformed
+}
+
+// WebP extended-format flags for metadata chunks.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+	size := 8 + int(binary.LittleEndian.Uint32(data[4:]))
+	if size > len(data) {
+		return nil, errMalformed
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	i := 12
+	for i+8 <= size {
+		end := i + 8 + int(binary.LittleEndian.Uint32(data[i+4:]))
+		end += end & 1 // chunks are padded to an even size
+		if end < i+8 || end > size {
+			return nil, errMalformed
+		}
+		switch fourcc := string(data[i : i+4]); fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			at := len(out)
+			out = append(out, data[i:end]...)
+			out[at+8] &^= webpFlagEXIF | webpFlagXMP
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// Orientation returns the EXIF orientation of JPEG data, from 1 (upright) to
+// 8, or 1 if it has none.
+func Orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return 1
+	}
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == markerSOS || marker == markerEOI {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			break
+		}
+		if payload := data[i+4 : end]; marker == markerAPP1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return exifOrientation(payload[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for e := ifd + 2; e+12 <= len(tiff) && count > 0; e, count = e+12, count-1 {
+		if order.Uint16(tiff[e:]) != 0x0112 {
+			continue
+		}
+		if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 1
+	}
+	return 1
+}
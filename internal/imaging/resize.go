@@ -0,0 +1,110 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	xdraw "golang.org/x/image/draw"
+
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+)
+
+// JPEGQuality is the quality resized JPEG variants are encoded with.
+const JPEGQuality = 82
+
+// Size returns the format of data ("jpeg", "png", "gif" or "webp") and the
+// dimensions it is displayed at, taking a JPEG's EXIF orientation into
+// account. It reads only the image header.
+func Size(data []byte) (format string, width, height int, err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if Orientation(data) >= 5 {
+		return format, cfg.Height, cfg.Width, nil
+	}
+	return format, cfg.Width, cfg.Height, nil
+}
+
+// Decode decodes data and turns it upright according to its EXIF
+// orientation.
+func Decode(data []byte) (*image.RGBA, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return orient(rgba, Orientation(data)), nil
+}
+
+// orient applies an EXIF orientation to img.
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// Find the source pixel shown at (x, y).
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], img.Pix[img.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+// Resize scales img to width pixels wide, keeping its aspect ratio.
+func Resize(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+	return dst
+}
+
+// Encode writes img in format, which is "jpeg", "png" or "webp". WebP
+// output is lossless.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+	case "png":
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		return enc.Encode(w, img)
+	case "webp":
+		return nativewebp.Encode(w, img, nil)
+	}
+	return errMalformed
+}
@@ -0,0 +1,70 @@
+package markdown
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+var codeParser = goldmark.DefaultParser()
+
+// MaskCode returns body with the contents of its code blocks and inline code
+// spans replaced by spaces, keeping line breaks, so text found in the result
+// is outside code and sits at the same offset and line in body. Code is found
+// the way CommonMark parses it: a fence is indented by at most three spaces
+// and closed by a run of the same character at least as long, a ``` fence's
+// info string has no backticks, four spaces of indentation start an indented
+// code block outside lists, and fences count in block quotes and list items
+// too.
+func MaskCode(body string) string {
+	if !strings.ContainsAny(body, "`~\t") && !strings.Contains(body, "    ") {
+		return body
+	}
+	src := []byte(body)
+	masked := []byte(body)
+	blank := func(start, stop int) {
+		for i := start; i < stop; i++ {
+			if masked[i] != '\n' && masked[i] != '\r' {
+				masked[i] = ' '
+			}
+		}
+	}
+
+	doc := codeParser.Parse(text.NewReader(src))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			if f, ok := n.(*ast.FencedCodeBlock); ok && f.Info != nil {
+				blank(f.Info.Segment.Start, f.Info.Segment.Stop)
+			}
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				s := lines.At(i)
+				blank(s.Start, s.Stop)
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeSpan:
+			// A span across lines has a text segment per line.
+			start, stop := -1, -1
+			for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+				if t, ok := c.(*ast.Text); ok {
+					if start < 0 {
+						start = t.Segment.Start
+					}
+					stop = t.Segment.Stop
+				}
+			}
+			if start >= 0 {
+				blank(start, stop)
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return string(masked)
+}
@@ -0,0 +1,37 @@
+package markdown
+
+import "testing"
+
+func TestMaskCode(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no code", "a #tag\n", "a #tag\n"},
+		{"inline code", "a `b` c", "a ` ` c"},
+		{"double backticks", "a `` b ` c `` d", "a ``       `` d"},
+		{"unmatched backtick", "a ` b", "a ` b"},
+		{"escaped backtick", "a \\` b ` c", "a \\` b ` c"},
+		{"inline code across lines", "a `b\nc` d", "a ` \n ` d"},
+		{"fence", "```go\n#x\n```\n#y", "```  \n  \n```\n#y"},
+		{"tilde fence", "~~~\n#x\n~~~\n#y", "~~~\n  \n~~~\n#y"},
+		{"inline code opening a line", "```x``` is code.\n#y", "``` ``` is code.\n#y"},
+		{"backticks in a fence's info string", "``` a`b\n#y", "``` a`b\n#y"},
+		{"indented by three spaces", "   ```\n#x\n   ```\n#y", "   ```\n  \n   ```\n#y"},
+		{"indented code block", "    ```\n\n#y", "       \n\n#y"},
+		{"closed by the other character", "~~~\n```\n#x\n~~~\n#y", "~~~\n   \n  \n~~~\n#y"},
+		{"closed by a shorter run", "````\n```\n#x\n````\n#y", "````\n   \n  \n````\n#y"},
+		{"closing fence indented by four", "```\n    ```\n#x\n```\n#y", "```\n       \n  \n```\n#y"},
+		{"closing fence with text after it", "```\n``` x\n#x\n```\n#y", "```\n     \n  \n```\n#y"},
+		{"unclosed fence", "```\n#x\n#y", "```\n  \n  "},
+		{"fence in a block quote", "> ```\n> #x\n> ```\n#y", "> ```\n>   \n> ```\n#y"},
+		{"fence in a list item", "- ```\n  #x\n  ```\n#y", "- ```\n    \n  ```\n#y"},
+		{"block quote ending a fence", "> ```\n> #x\n\n#y", "> ```\n>   \n\n#y"},
+	}
+	for _, tt := range tests {
+		if got := MaskCode(tt.body); got != tt.want {
+			t.Errorf("%s: MaskCode(%q)\n got %q\nwant %q", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package markdown
+
+import "strings"
+
+// StripComments removes Obsidian comments (%%like this%%, which may span
+// lines) from a markdown body. %% inside code is left alone. Like Obsidian,
+// an unclosed %% hides the rest of the note.
+func StripComments(body string) string {
+	if !strings.Contains(body, "%%") {
+		return body
+	}
+
+	// Comments are found in the masked body, where code is blanked out, and
+	// everything else is copied from body.
+	masked := MaskCode(body)
+	var sb strings.Builder
+	for i := 0; ; {
+		start := strings.Index(masked[i:], "%%")
+		if start < 0 {
+			sb.WriteString(body[i:])
+			break
+		}
+		sb.WriteString(body[i : i+start])
+		i += start + 2
+		end := strings.Index(masked[i:], "%%")
+		if end < 0 {
+			break
+		}
+		i += end + 2
+	}
+	return sb.String()
+}
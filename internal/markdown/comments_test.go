@@ -0,0 +1,34 @@
+package markdown
+
+import "testing"
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"inline", "a %%secret%% b", "a  b"},
+		{"across lines", "a\n%%\nsecret\n%%\nb", "a\n\nb"},
+		{"unclosed", "a\n%% secret\nmore", "a\n"},
+		{"in inline code", "a `%%kept%%` b", "a `%%kept%%` b"},
+		{"in a fence", "```\n%%kept%%\n```\n%%secret%%", "```\n%%kept%%\n```\n"},
+		{"around a fence", "%%\n```\ncode\n```\n%%\nb", "\nb"},
+
+		// Lines that look like fences but aren't must not hide comments
+		// after them from being stripped.
+		{"inline code opening a line", "```x``` is inline code.\n%%secret%%\n", "```x``` is inline code.\n\n"},
+		{"indented code block", "    ```\n\n%%secret%%\n", "    ```\n\n\n"},
+		{"backticks in a fence's info string", "``` a`b\n%%secret%%\n", "``` a`b\n\n"},
+		{"fence closed by the other character", "~~~\n```\n~~~\n%%secret%%\n", "~~~\n```\n~~~\n\n"},
+		{"fence closed by a shorter run", "````\n```\n%%kept%%\n````\n%%secret%%\n", "````\n```\n%%kept%%\n````\n\n"},
+		{"fence in a list item", "- ```\n  x\n  ```\n\n%%secret%%\n", "- ```\n  x\n  ```\n\n\n"},
+		{"fence in a block quote", "> ```\n> x\n\n%%secret%%\n", "> ```\n> x\n\n\n"},
+		{"escaped backtick", "\\`%%secret%%`", "\\``"},
+	}
+	for _, tt := range tests {
+		if got := StripComments(tt.body); got != tt.want {
+			t.Errorf("%s: StripComments(%q)\n got %q\nwant %q", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
@@ -3,41 +3,261 @@ package markdown
 import (
 	"bufio"
 	"os"
+	"path"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Frontmatter struct {
-	Title   string `yaml:"title"`
-	Publish bool   `yaml:"publish"`
-	Date    string `yaml:"date"`
-	Group   string `yaml:"group"`
+	Title   string      `yaml:"title"`
+	Publish PublishFlag `yaml:"publish"`
+	Preview bool        `yaml:"preview"` // same as publish: draft
+	Date    string      `yaml:"date"`
+	Group   string      `yaml:"group"`
+	Tags    Tags        `yaml:"tags"`
+	Order   int         `yaml:"order"`
+	Weight  int         `yaml:"weight"` // alias for order
+	Feed    *bool       `yaml:"feed"`
+	Aliases Names       `yaml:"aliases"`
+	TOC     *bool       `yaml:"toc"`     // forces the table of contents on or off
+	NoIndex bool        `yaml:"noindex"` // keeps search engines away from the note
+
+	// URLs: a slug or permalink replaces the one derived from the note's
+	// path, and redirect_from lists old URLs that redirect to the note.
+	CustomSlug   string `yaml:"slug"`
+	Permalink    string `yaml:"permalink"`
+	RedirectFrom Names  `yaml:"redirect_from"`
+
+	// Scheduling: a note with publish_at is published from that time on,
+	// even without publish: true, and one with unpublish_at until then.
+	PublishAt   Timestamp `yaml:"publish_at"`
+	UnpublishAt Timestamp `yaml:"unpublish_at"`
+
+	// Protection: a published note with a password, or access limited to
+	// one or more groups of users, is only served after logging in.
+	Password string `yaml:"password"`
+	Access   Names  `yaml:"access"`
+
+	// Link previews: a summary, which defaults to the first paragraph, and
+	// an image, which defaults to a generated card.
+	Description string `yaml:"description"`
+	Image       string `yaml:"image"`
+	Cover       string `yaml:"cover"` // alias for image
+
+	// Markdown features, overriding the site's settings when set.
+	Math            *bool `yaml:"math"`
+	Footnotes       *bool `yaml:"footnotes"`
+	Sidenotes       *bool `yaml:"sidenotes"`
+	DefinitionLists *bool `yaml:"definition_lists"`
+	Typographer     *bool `yaml:"typographer"`
+	Emoji           *bool `yaml:"emoji"`
+}
+
+// Names is a list of strings that may also be written as a single string,
+// like Obsidian's aliases field.
+type Names []string
+
+func (n *Names) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var raw []string
+		if err := node.Decode(&raw); err != nil {
+			return err
+		}
+		*n = raw
+	case yaml.ScalarNode:
+		if v := strings.TrimSpace(node.Value); v != "" {
+			*n = Names{v}
+		}
+	}
+	return nil
+}
+
+// PublishFlag is the publish field: true, false, or "draft" for a note that
+// is only shown at a secret preview URL. PublishUnset is a missing field.
+type PublishFlag int
+
+const (
+	PublishUnset PublishFlag = iota
+	PublishOff
+	PublishOn
+	PublishDraft
+)
+
+func (p *PublishFlag) UnmarshalYAML(node *yaml.Node) error {
+	*p = PublishOff
+	if strings.EqualFold(strings.TrimSpace(node.Value), "draft") {
+		*p = PublishDraft
+		return nil
+	}
+	var on bool
+	if err := node.Decode(&on); err == nil && on {
+		*p = PublishOn
+	}
+	return nil
+}
+
+// Timestamp is a point in time in frontmatter, written as a date, a date
+// and time like "2026-11-01 09:00", or in RFC 3339. Times without a zone are
+// in the local time zone.
+type Timestamp struct {
+	time.Time
+	Raw string // as written; set even if it could not be parsed
+}
+
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func (t *Timestamp) UnmarshalYAML(node *yaml.Node) error {
+	t.Raw = strings.TrimSpace(node.Value)
+	for _, layout := range timestampLayouts {
+		if v, err := time.ParseInLocation(layout, t.Raw, time.Local); err == nil {
+			t.Time = v
+			break
+		}
+	}
+	return nil
+}
+
+// Valid reports whether the timestamp is unset or could be parsed.
+func (t Timestamp) Valid() bool {
+	return t.Raw == "" || !t.IsZero()
+}
+
+// SortOrder returns the note's position within its group; lower sorts first.
+// Zero means unset.
+func (fm Frontmatter) SortOrder() int {
+	if fm.Order != 0 {
+		return fm.Order
+	}
+	return fm.Weight
+}
+
+// CoverImage returns the note's preview image reference, if any: a path or
+// URL, with the brackets of an embed like "![[photo.png]]" removed.
+func (fm Frontmatter) CoverImage() string {
+	ref := fm.Image
+	if ref == "" {
+		ref = fm.Cover
+	}
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "!")
+	if strings.HasPrefix(ref, "[[") && strings.HasSuffix(ref, "]]") {
+		ref, _ = ParseImageEmbed(ref[2 : len(ref)-2])
+	}
+	return ref
+}
+
+// Published reports whether the note is meant to be published, now or at
+// its publish_at time. publish_at implies publish: true only when the note
+// doesn't set publish itself.
+func (fm Frontmatter) Published() bool {
+	return fm.Publish == PublishOn || fm.Publish == PublishUnset && fm.PublishAt.Raw != ""
+}
+
+// Draft reports whether the note asks for a preview page, shown until it is
+// published.
+func (fm Frontmatter) Draft() bool {
+	return fm.Preview || fm.Publish == PublishDraft
+}
+
+// LiveAt reports whether a published note is visible at time t: after its
+// publish_at and before its unpublish_at. A timestamp that could not be
+// parsed keeps the note hidden.
+func (fm Frontmatter) LiveAt(t time.Time) bool {
+	if !fm.Published() || !fm.PublishAt.Valid() || !fm.UnpublishAt.Valid() {
+		return false
+	}
+	if !fm.PublishAt.IsZero() && t.Before(fm.PublishAt.Time) {
+		return false
+	}
+	return fm.UnpublishAt.IsZero() || t.Before(fm.UnpublishAt.Time)
+}
+
+// PermalinkSlug returns the slug set with permalink or slug, or "" if the
+// note has none.
+func (fm Frontmatter) PermalinkSlug() string {
+	if fm.Permalink != "" {
+		return SlugPath(fm.Permalink)
+	}
+	return SlugPath(fm.CustomSlug)
+}
+
+// RedirectPaths returns the old URL paths that redirect to the note, without
+// the leading slash: those in redirect_from and the aliases written as
+// paths, like "/old-name". They are kept as written, since they are URLs
+// the note had elsewhere ("/2019/01/post.html", "/About/").
+func (fm Frontmatter) RedirectPaths() []string {
+	var paths []string
+	add := func(p string) {
+		if p = strings.Trim(path.Clean("/"+strings.TrimSpace(p)), "/"); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	for _, p := range fm.RedirectFrom {
+		add(p)
+	}
+	for _, a := range fm.Aliases {
+		if strings.HasPrefix(a, "/") {
+			add(a)
+		}
+	}
+	return paths
+}
+
+// Protected reports whether the note is only served after logging in.
+func (fm Frontmatter) Protected() bool {
+	return fm.Password != "" || len(fm.Access) > 0
+}
+
+// InFeed reports whether a published note should appear in feeds.
+// Notes are included unless they set feed: false.
+func (fm Frontmatter) InFeed() bool {
+	return fm.Feed == nil || *fm.Feed
 }
 
 // ParseFrontmatter splits markdown content into YAML frontmatter and body.
 func ParseFrontmatter(content string) (Frontmatter, string) {
 	var fm Frontmatter
+	fmBlock, body, ok := SplitFrontmatter(content)
+	if ok {
+		_ = yaml.Unmarshal([]byte(fmBlock), &fm)
+	}
+	return fm, body
+}
+
+// SplitFrontmatter splits markdown content into its raw YAML frontmatter block
+// and the body. ok is false if the content has no frontmatter, in which case
+// body is the whole (trimmed) content.
+func SplitFrontmatter(content string) (fmBlock, body string, ok bool) {
 	content = strings.TrimSpace(content)
 	if !strings.HasPrefix(content, "---") {
-		return fm, content
+		return "", content, false
 	}
 
 	rest := content[3:]
 	endIdx := strings.Index(rest, "\n---")
 	if endIdx == -1 {
-		return fm, content
+		return "", content, false
 	}
 
-	fmBlock := rest[:endIdx]
-	body := rest[endIdx+4:] // skip \n---
-
-	_ = yaml.Unmarshal([]byte(fmBlock), &fm)
-	return fm, strings.TrimSpace(body)
+	fmBlock = rest[:endIdx]
+	body = rest[endIdx+4:] // skip \n---
+	return fmBlock, strings.TrimSpace(body), true
 }
 
 // IsPublished reads a markdown file and returns true if its YAML frontmatter
-// contains publish: true.
+// contains publish: true, or a publish_at time and no publish field, or asks
+// for a draft preview.
+// Scheduled notes count as published whether or not their time has come; the
+// site hides them until then.
 func IsPublished(path string) bool {
 	f, err := os.Open(path)
 	if err != nil {
@@ -69,5 +289,5 @@ func IsPublished(path string) bool {
 	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &fm); err != nil {
 		return false
 	}
-	return fm.Publish
+	return fm.Published() || fm.Draft()
 }
@@ -0,0 +1,25 @@
+package markdown
+
+import "testing"
+
+func TestPublished(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want bool
+	}{
+		{"title: x", false},
+		{"publish: true", true},
+		{"publish: false", false},
+		{"publish: draft", false},
+		{"publish_at: 2026-11-01", true},
+		{"publish: true\npublish_at: 2026-11-01", true},
+		{"publish: false\npublish_at: 2026-11-01", false},
+		{"publish: draft\npublish_at: 2026-11-01", false},
+	}
+	for _, tt := range tests {
+		fm, _ := ParseFrontmatter("---\n" + tt.yaml + "\n---\nbody")
+		if got := fm.Published(); got != tt.want {
+			t.Errorf("%q: Published() = %v, want %v", tt.yaml, got, tt.want)
+		}
+	}
+}
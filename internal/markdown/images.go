@@ -1,8 +1,13 @@
 package markdown
 
 import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/nilszeilon/notesync/internal/fileutil"
@@ -11,33 +16,42 @@ import (
 var (
 	ObsidianEmbedRe = regexp.MustCompile(`!\[\[([^\]]+)\]\]`)
 	mdImageRe       = regexp.MustCompile(`!\[[^\]]*\]\(([^)]+)\)`)
+	htmlImageRe     = regexp.MustCompile(`(?i)<img\s[^>]*?\bsrc\s*=\s*(?:"([^"]*)"|'([^']*)')`)
 )
 
-// ExtractImageRefs returns image filenames referenced in markdown content
-// via Obsidian embeds ![[image.png]] and standard markdown ![alt](image.png).
-func ExtractImageRefs(content string) []string {
+// ParseImageEmbed splits the text between an image embed's brackets into
+// the image path and the text on the other side of the '|', if any. Obsidian
+// writes ![[photo.png|300]], older notes ![[alt text|photo.png]].
+func ParseImageEmbed(inner string) (path, label string) {
+	before, after, _ := strings.Cut(inner, "|")
+	before, after = strings.TrimSpace(before), strings.TrimSpace(after)
+	if fileutil.IsImage(before) {
+		return before, after
+	}
+	return after, before
+}
+
+// ExtractImagePaths returns image references in markdown content as written,
+// in order of appearance, once each. References in code are only text and
+// are skipped.
+func ExtractImagePaths(content string) []string {
+	content = MaskCode(content)
 	seen := make(map[string]bool)
-	var refs []string
+	var paths []string
 
 	add := func(name string) {
 		name = strings.TrimSpace(name)
-		if name == "" || !fileutil.IsImage(name) {
+		if name == "" || !fileutil.IsImage(name) || seen[name] {
 			return
 		}
-		base := filepath.Base(name)
-		if !seen[base] {
-			seen[base] = true
-			refs = append(refs, base)
-		}
+		seen[name] = true
+		paths = append(paths, name)
 	}
 
-	// ![[image.png]] or ![[alt|image.png]]
+	// ![[image.png]], ![[image.png|300]] or ![[alt|image.png]]
 	for _, m := range ObsidianEmbedRe.FindAllStringSubmatch(content, -1) {
-		inner := m[1]
-		if idx := strings.Index(inner, "|"); idx != -1 {
-			inner = inner[idx+1:]
-		}
-		add(inner)
+		p, _ := ParseImageEmbed(m[1])
+		add(p)
 	}
 
 	// ![alt](image.png)
@@ -45,5 +59,124 @@ func ExtractImageRefs(content string) []string {
 		add(m[1])
 	}
 
-	return refs
+	// <img src="/images/image.png">
+	for _, m := range htmlImageRe.FindAllStringSubmatch(content, -1) {
+		add(m[1] + m[2])
+	}
+
+	return paths
+}
+
+// ImageIndex resolves image references in notes to the images in a vault,
+// the way Obsidian does.
+type ImageIndex struct {
+	paths       map[string]bool     // vault-relative, slash-separated
+	byName      map[string][]string // lowercased file name -> paths
+	attachments string              // Obsidian's attachment folder setting
+}
+
+// NewImageIndex indexes the images at paths, which are relative to the vault
+// root. attachments is Obsidian's "Default location for new attachments"
+// setting as stored in .obsidian/app.json: "/" for the vault root, "./" for
+// the note's folder, "./name" for a subfolder of it, or a folder path.
+func NewImageIndex(paths []string, attachments string) *ImageIndex {
+	x := &ImageIndex{
+		paths:       make(map[string]bool),
+		byName:      make(map[string][]string),
+		attachments: strings.TrimSpace(attachments),
+	}
+	for _, p := range paths {
+		p = filepath.ToSlash(p)
+		x.paths[p] = true
+		name := strings.ToLower(path.Base(p))
+		x.byName[name] = append(x.byName[name], p)
+	}
+	return x
+}
+
+// Resolve returns the vault path of the image ref points to in the note at
+// notePath. ref may be URL-escaped, as in markdown links. It is tried, in
+// order, as a path relative to the note, relative to the vault root and
+// relative to the note's attachment folder. Failing that, a name or partial
+// path matches any image whose path ends with it; of several, the one with
+// the shortest path wins. Refs starting with "/" only match from the root,
+// and refs starting with "./" or "../" only relative to the note.
+func (x *ImageIndex) Resolve(notePath, ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if u, err := url.PathUnescape(ref); err == nil {
+		ref = u
+	}
+	if ref == "" {
+		return "", false
+	}
+	dir := path.Dir(filepath.ToSlash(notePath))
+	if strings.HasPrefix(ref, "/") {
+		p := path.Clean(strings.TrimPrefix(ref, "/"))
+		if rel, ok := strings.CutPrefix(p, "images/"); ok && !x.paths[p] && x.paths[rel] {
+			// The image's URL on the site, as in <img src="/images/...">
+			return rel, true
+		}
+		return p, x.paths[p]
+	}
+	if p := path.Join(dir, ref); x.paths[p] {
+		return p, true
+	}
+	if strings.HasPrefix(ref, "./") || strings.HasPrefix(ref, "../") {
+		return "", false
+	}
+	if p := path.Clean(ref); x.paths[p] {
+		return p, true
+	}
+	if a := x.attachmentDir(dir); a != "" {
+		if p := path.Join(a, ref); x.paths[p] {
+			return p, true
+		}
+	}
+
+	var matches []string
+	for _, p := range x.byName[strings.ToLower(path.Base(ref))] {
+		if len(p) >= len(ref) && strings.EqualFold(p[len(p)-len(ref):], ref) && (len(p) == len(ref) || p[len(p)-len(ref)-1] == '/') {
+			matches = append(matches, p)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		di, dj := strings.Count(matches[i], "/"), strings.Count(matches[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return matches[i] < matches[j]
+	})
+	return matches[0], true
+}
+
+// attachmentDir returns the folder new attachments of notes in noteDir are
+// saved to, or "" for the vault root.
+func (x *ImageIndex) attachmentDir(noteDir string) string {
+	a := x.attachments
+	switch {
+	case a == "" || a == "/":
+		return ""
+	case a == "." || strings.HasPrefix(a, "./"):
+		return path.Join(noteDir, a)
+	}
+	return path.Clean(strings.Trim(a, "/"))
+}
+
+// AttachmentFolder returns the attachment folder setting of the Obsidian
+// vault at dir, or "" if it has none.
+func AttachmentFolder(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, ".obsidian", "app.json"))
+	if err != nil {
+		return ""
+	}
+	var app struct {
+		AttachmentFolderPath string `json:"attachmentFolderPath"`
+	}
+	if err := json.Unmarshal(data, &app); err != nil {
+		return ""
+	}
+	return app.AttachmentFolderPath
 }
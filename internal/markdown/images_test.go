@@ -0,0 +1,72 @@
+package markdown
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestImageIndexResolve(t *testing.T) {
+	vault := []string{
+		"photo.png",
+		"my photo.png",
+		"notes/photo.png",
+		"notes/assets/pic.png",
+		"a/diagram.png",
+		"b/x/diagram.png",
+		"c/diagram.png",
+		"a/att.png",
+		"attachments/att.png",
+		"z/pic.png",
+	}
+	tests := []struct {
+		name        string
+		attachments string
+		note, ref   string
+		want        string // "" if unresolved
+	}{
+		{"relative to the note", "", "notes/n.md", "photo.png", "notes/photo.png"},
+		{"vault root", "", "other/n.md", "photo.png", "photo.png"},
+		{"relative before the root", "", "notes/n.md", "photo.png", "notes/photo.png"},
+		{"./ only relative", "", "notes/n.md", "./photo.png", "notes/photo.png"},
+		{"../ only relative", "", "notes/n.md", "../photo.png", "photo.png"},
+		{"./ without a match", "", "other/n.md", "./photo.png", ""},
+		{"/ only from the root", "", "notes/n.md", "/photo.png", "photo.png"},
+		{"/ without a match", "", "n.md", "/diagram.png", ""},
+		{"site URL", "", "n.md", "/images/notes/photo.png", "notes/photo.png"},
+		{"URL-escaped", "", "n.md", "my%20photo.png", "my photo.png"},
+		{"attachment folder", "attachments", "notes/n.md", "att.png", "attachments/att.png"},
+		{"root before the attachment folder", "attachments", "other/n.md", "photo.png", "photo.png"},
+		{"attachment subfolder of the note's", "./assets", "notes/n.md", "pic.png", "notes/assets/pic.png"},
+		{"no attachment folder", "", "notes/n.md", "att.png", "a/att.png"},
+
+		// Two diagram.png files: the note's own wins, then the shortest
+		// path, then the first in order.
+		{"same name next to the note", "", "b/x/n.md", "diagram.png", "b/x/diagram.png"},
+		{"same name elsewhere", "", "n.md", "diagram.png", "a/diagram.png"},
+		{"partial path", "", "n.md", "x/diagram.png", "b/x/diagram.png"},
+		{"partial path of another folder", "", "n.md", "c/diagram.png", "c/diagram.png"},
+		{"case-insensitive suffix", "", "n.md", "X/Diagram.PNG", "b/x/diagram.png"},
+		{"partial name", "", "n.md", "gram.png", ""},
+		{"missing", "", "n.md", "nothing.png", ""},
+	}
+	for _, tt := range tests {
+		x := NewImageIndex(vault, tt.attachments)
+		got, ok := x.Resolve(tt.note, tt.ref)
+		if !ok {
+			got = ""
+		}
+		if got != tt.want {
+			t.Errorf("%s: Resolve(%q, %q) = %q, want %q", tt.name, tt.note, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestExtractImagePaths(t *testing.T) {
+	body := "![[a.png]] ![[b.jpg|300]] ![[alt|c.gif]] ![x](d%20e.png) ![[a.png]]\n" +
+		"<img src=\"/images/f.webp\"> [[note]] ![[note]]\n" +
+		"`![[code.png]]`\n\n```\n![](fenced.png)\n```\n"
+	want := []string{"a.png", "b.jpg", "c.gif", "d%20e.png", "/images/f.webp"}
+	if got := ExtractImagePaths(body); !slices.Equal(got, want) {
+		t.Errorf("ExtractImagePaths = %q, want %q", got, want)
+	}
+}
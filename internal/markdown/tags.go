@@ -0,0 +1,90 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tags is a list of tags from frontmatter. Like Obsidian, it accepts either a
+// YAML list or a single string of comma- or space-separated tags, with or
+// without a leading '#'.
+type Tags []string
+
+func (t *Tags) UnmarshalYAML(node *yaml.Node) error {
+	var raw []string
+	switch node.Kind {
+	case yaml.SequenceNode:
+		if err := node.Decode(&raw); err != nil {
+			return err
+		}
+	case yaml.ScalarNode:
+		raw = strings.FieldsFunc(node.Value, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+	}
+	*t = MergeTags(nil, raw)
+	return nil
+}
+
+var (
+	inlineTagRe = regexp.MustCompile(`(?:^|[\s(\[,;])#([\p{L}\p{N}_/-]+)`)
+	linkDestRe  = regexp.MustCompile(`\]\([^)]*\)`)
+	wikiLinkRe  = regexp.MustCompile(`!?\[\[[^\]]*\]\]`)
+	headingRe   = regexp.MustCompile(`^\s{0,3}#{1,6}(\s|$)`)
+)
+
+// ExtractTags returns the inline #tags in a markdown body, skipping code,
+// headings, link destinations ("[see](#section)") and wikilinks
+// ("[[#Heading]]").
+func ExtractTags(body string) []string {
+	var found []string
+	for _, line := range strings.Split(MaskCode(body), "\n") {
+		if headingRe.MatchString(line) {
+			continue
+		}
+		line = linkDestRe.ReplaceAllString(line, "]")
+		line = wikiLinkRe.ReplaceAllString(line, " ")
+		for _, m := range inlineTagRe.FindAllStringSubmatch(line, -1) {
+			found = append(found, m[1])
+		}
+	}
+	return MergeTags(nil, found)
+}
+
+// MergeTags appends the valid tags in add to tags, dropping duplicates.
+// Tags compare case-insensitively; the first spelling seen is kept.
+func MergeTags(tags []string, add []string) []string {
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		seen[strings.ToLower(t)] = true
+	}
+	for _, t := range add {
+		t = strings.Trim(strings.TrimSpace(t), "#/")
+		if !validTag(t) || seen[strings.ToLower(t)] {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// validTag rejects empty and purely numeric tags, which Obsidian doesn't
+// treat as tags either (e.g. "#1" in "issue #1").
+func validTag(t string) bool {
+	return strings.TrimFunc(t, func(r rune) bool {
+		return r >= '0' && r <= '9' || r == '/' || r == '-' || r == '_'
+	}) != ""
+}
+
+// TagSlug converts a tag to a URL path, keeping nested tags ("area/topic")
+// as nested paths.
+func TagSlug(tag string) string {
+	parts := strings.Split(tag, "/")
+	for i, p := range parts {
+		parts[i] = Slugify(p)
+	}
+	return strings.Join(parts, "/")
+}
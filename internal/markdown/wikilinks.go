@@ -3,35 +3,133 @@ package markdown
 import (
 	"regexp"
 	"strings"
+	"unicode"
+
+	"github.com/nilszeilon/notesync/internal/fileutil"
 )
 
 var WikilinkRe = regexp.MustCompile(`\[\[([^\]]+)\]\]`)
 
-// ExtractWikiLinks returns all [[wiki-link]] targets from markdown content,
-// excluding image embeds (![[image.png]]).
-func ExtractWikiLinks(content string) []string {
-	// Remove image embeds first so they aren't matched as wikilinks
-	cleaned := ObsidianEmbedRe.ReplaceAllString(content, "")
+// WikiLink is a parsed [[target#anchor|display]] link.
+type WikiLink struct {
+	Target  string // note name or path; empty for links within the same note
+	Heading string // from [[note#Heading]]
+	Block   string // from [[note#^block-id]], without the '^'
+	Display string // from [[note|display]]; empty if not given
+}
 
-	matches := WikilinkRe.FindAllStringSubmatch(cleaned, -1)
-	seen := make(map[string]bool)
-	var links []string
-	for _, m := range matches {
-		target := m[1]
-		// Handle [[target|display]] syntax (Obsidian convention)
-		if idx := strings.Index(target, "|"); idx != -1 {
-			target = target[:idx]
+// ParseWikiLink parses the text between a wikilink's brackets.
+func ParseWikiLink(inner string) WikiLink {
+	var l WikiLink
+	target := inner
+	if idx := strings.Index(inner, "|"); idx != -1 {
+		target = inner[:idx]
+		l.Display = strings.TrimSpace(inner[idx+1:])
+	}
+	// Inside tables the pipe is escaped: [[note\|display]]
+	target = strings.TrimSuffix(target, `\`)
+	if idx := strings.Index(target, "#"); idx != -1 {
+		anchor := strings.TrimSpace(target[idx+1:])
+		target = target[:idx]
+		if strings.HasPrefix(anchor, "^") {
+			l.Block = anchor[1:]
+		} else {
+			l.Heading = anchor
 		}
-		target = strings.TrimSpace(target)
-		slug := Slugify(target)
-		if !seen[slug] {
-			seen[slug] = true
-			links = append(links, slug)
+	}
+	l.Target = strings.TrimSpace(target)
+	return l
+}
+
+// Text is the link's display text: the explicit display text if given,
+// otherwise the target and heading as written.
+func (l WikiLink) Text() string {
+	switch {
+	case l.Display != "":
+		return l.Display
+	case l.Heading != "" && l.Target != "":
+		return l.Target + " › " + l.Heading
+	case l.Heading != "":
+		return l.Heading
+	}
+	return l.Target
+}
+
+// ExtractWikiLinks returns all [[wiki-links]] in markdown content, excluding
+// embeds (![[image.png]]). Each distinct link is returned once.
+func ExtractWikiLinks(content string) []WikiLink {
+	// Remove embeds first so they aren't matched as wikilinks
+	cleaned := ObsidianEmbedRe.ReplaceAllString(content, "")
+
+	seen := make(map[WikiLink]bool)
+	var links []WikiLink
+	for _, m := range WikilinkRe.FindAllStringSubmatch(cleaned, -1) {
+		l := ParseWikiLink(m[1])
+		l.Display = ""
+		if !seen[l] {
+			seen[l] = true
+			links = append(links, l)
 		}
 	}
 	return links
 }
 
+// IsNoteEmbed reports whether the text between an embed's brackets refers to
+// a note rather than an image. Images may come before or after the '|', as in
+// ![[photo.png|300]] or ![[alt text|photo.png]].
+func IsNoteEmbed(inner string) bool {
+	before, after, _ := strings.Cut(inner, "|")
+	return !fileutil.IsImage(strings.TrimSpace(before)) && !fileutil.IsImage(strings.TrimSpace(after))
+}
+
+// ExtractEmbeds returns the notes embedded in markdown content with
+// ![[note]], ![[note#Heading]] or ![[note#^block-id]], once each.
+func ExtractEmbeds(content string) []WikiLink {
+	seen := make(map[WikiLink]bool)
+	var embeds []WikiLink
+	for _, m := range ObsidianEmbedRe.FindAllStringSubmatch(content, -1) {
+		if !IsNoteEmbed(m[1]) {
+			continue
+		}
+		l := ParseWikiLink(m[1])
+		l.Display = ""
+		if !seen[l] {
+			seen[l] = true
+			embeds = append(embeds, l)
+		}
+	}
+	return embeds
+}
+
+// BlockIDRe matches an Obsidian block ID (" ^block-id") at the end of a line.
+var BlockIDRe = regexp.MustCompile(`(?m)(^|[ \t])\^([A-Za-z0-9-]+)[ \t]*$`)
+
+// HeadingID converts heading text to the id its HTML element gets, which is
+// also the anchor [[note#Heading]] links point at: lowercased letters and
+// digits, with runs of anything else turned into single dashes.
+func HeadingID(text string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			dash = false
+			sb.WriteRune(r)
+			continue
+		}
+		if r == '*' || r == '`' || r == '\'' || r == '’' {
+			continue // formatting and apostrophes don't separate words
+		}
+		dash = true
+	}
+	if sb.Len() == 0 {
+		return "section"
+	}
+	return sb.String()
+}
+
 // Slugify converts a note title to a URL-safe slug.
 func Slugify(name string) string {
 	s := strings.ToLower(strings.TrimSpace(name))
@@ -51,3 +149,15 @@ func Slugify(name string) string {
 	s = strings.Trim(s, "-")
 	return s
 }
+
+// SlugPath converts a URL path like "/blog/My Post/" to a slug like
+// "blog/my-post", slugifying each segment.
+func SlugPath(p string) string {
+	var parts []string
+	for _, seg := range strings.Split(p, "/") {
+		if s := Slugify(seg); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,51 @@
+package mathml
+
+// alphabets holds where each styled alphabet starts in the Mathematical
+// Alphanumeric Symbols block: capital letters, small letters and digits.
+// Alphabets without styled digits have 0.
+var alphabets = map[string][3]rune{
+	"bold":          {0x1D400, 0x1D41A, 0x1D7CE},
+	"italic":        {0x1D434, 0x1D44E, 0},
+	"bold-italic":   {0x1D468, 0x1D482, 0},
+	"script":        {0x1D49C, 0x1D4B6, 0},
+	"fraktur":       {0x1D504, 0x1D51E, 0},
+	"double-struck": {0x1D538, 0x1D552, 0x1D7D8},
+	"sans-serif":    {0x1D5A0, 0x1D5BA, 0x1D7E2},
+	"monospace":     {0x1D670, 0x1D68A, 0x1D7F6},
+}
+
+// alphabetHoles are the letters that were encoded elsewhere before the
+// block existed, so their slots in it are empty.
+var alphabetHoles = map[rune]rune{
+	0x1D455: 'ℎ',
+	0x1D49D: 'ℬ', 0x1D4A0: 'ℰ', 0x1D4A1: 'ℱ', 0x1D4A3: 'ℋ', 0x1D4A4: 'ℐ',
+	0x1D4A7: 'ℒ', 0x1D4A8: 'ℳ', 0x1D4AD: 'ℛ', 0x1D4BA: 'ℯ', 0x1D4BC: 'ℊ',
+	0x1D4C4: 'ℴ',
+	0x1D506: 'ℭ', 0x1D50B: 'ℌ', 0x1D50C: 'ℑ', 0x1D515: 'ℜ', 0x1D51D: 'ℨ',
+	0x1D53A: 'ℂ', 0x1D53F: 'ℍ', 0x1D545: 'ℕ', 0x1D547: 'ℙ', 0x1D548: 'ℚ',
+	0x1D549: 'ℝ', 0x1D551: 'ℤ',
+}
+
+// styleRune returns r in the named alphabet, or r itself if the alphabet
+// has no such character.
+func styleRune(r rune, variant string) rune {
+	a, ok := alphabets[variant]
+	if !ok {
+		return r
+	}
+	var styled rune
+	switch {
+	case r >= 'A' && r <= 'Z':
+		styled = a[0] + r - 'A'
+	case r >= 'a' && r <= 'z':
+		styled = a[1] + r - 'a'
+	case r >= '0' && r <= '9' && a[2] != 0:
+		styled = a[2] + r - '0'
+	default:
+		return r
+	}
+	if hole, ok := alphabetHoles[styled]; ok {
+		return hole
+	}
+	return styled
+}
@@ -0,0 +1,758 @@
+// Package mathml converts LaTeX math to MathML, so formulas in notes render
+// in the browser without any JavaScript. It covers the subset of LaTeX that
+// notes use in practice: symbols and Greek letters, fractions, roots,
+// scripts and limits, \left/\right delimiters, accents, font commands,
+// \text, spacing, and the matrix, cases and aligned environments.
+package mathml
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Convert renders tex as a <math> element, as a block when display is set.
+// The source is kept as an annotation so it can be copied. Unknown commands
+// are shown inline as errors; malformed input such as unbalanced braces
+// returns an error.
+func Convert(tex string, display bool) (string, error) {
+	p := &parser{src: []rune(tex)}
+	body, err := p.parseTop()
+	if err != nil {
+		return "", err
+	}
+	attr := ""
+	if display {
+		attr = ` display="block"`
+	}
+	return `<math` + attr + `><semantics><mrow>` + body + `</mrow>` +
+		`<annotation encoding="application/x-tex">` + html.EscapeString(strings.TrimSpace(tex)) + `</annotation>` +
+		`</semantics></math>`, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokChar
+	tokNumber
+	tokCommand
+)
+
+type token struct {
+	kind tokenKind
+	text string // the character, number, or command name without '\'
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// node is one element of a row.
+type node struct {
+	xml    string
+	limits bool // scripts go below and above, as for \sum
+	fn     bool // a function name, followed by an invisible function application
+}
+
+type parser struct {
+	src     []rune
+	pos     int
+	variant string // alphabet for letters and digits, set by \mathbb and friends
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *parser) next() token {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return token{kind: tokEOF}
+	}
+	r := p.src[p.pos]
+	start := p.pos
+	p.pos++
+	switch {
+	case r == '\\':
+		if p.pos >= len(p.src) {
+			return token{kind: tokChar, text: `\`}
+		}
+		start = p.pos
+		if isASCIILetter(p.src[p.pos]) {
+			for p.pos < len(p.src) && isASCIILetter(p.src[p.pos]) {
+				p.pos++
+			}
+		} else {
+			p.pos++
+		}
+		return token{kind: tokCommand, text: string(p.src[start:p.pos])}
+	case r >= '0' && r <= '9':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) ||
+			p.src[p.pos] == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1])) {
+			p.pos++
+		}
+		return token{kind: tokNumber, text: string(p.src[start:p.pos])}
+	}
+	return token{kind: tokChar, text: string(r)}
+}
+
+func (p *parser) peek() token {
+	pos := p.pos
+	t := p.next()
+	p.pos = pos
+	return t
+}
+
+func isASCIILetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// parseTop parses the whole formula. Lines separated by \\ are stacked.
+func (p *parser) parseTop() (string, error) {
+	var rows [][]node
+	for {
+		row, err := p.parseRow(0)
+		if err != nil {
+			return "", err
+		}
+		rows = append(rows, row)
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			if len(rows) == 1 {
+				return rowXML(rows[0]), nil
+			}
+			var b strings.Builder
+			b.WriteString(`<mtable displaystyle="true">`)
+			for _, r := range rows {
+				b.WriteString("<mtr><mtd>" + rowXML(r) + "</mtd></mtr>")
+			}
+			b.WriteString("</mtable>")
+			return b.String(), nil
+		case t.is(tokCommand, `\`), t.is(tokCommand, "cr"), t.is(tokCommand, "newline"):
+			continue
+		case t.kind == tokCommand:
+			return "", fmt.Errorf(`unexpected \%s`, t.text)
+		default:
+			return "", fmt.Errorf("unexpected %q", t.text)
+		}
+	}
+}
+
+// stops reports whether t ends the current row: a closing brace, a cell or
+// line separator, or the end of an environment or delimited group.
+func stops(t token, until rune) bool {
+	switch t.kind {
+	case tokEOF:
+		return true
+	case tokChar:
+		return t.text == "}" || t.text == "&" || until != 0 && t.text == string(until)
+	case tokCommand:
+		switch t.text {
+		case `\`, "cr", "newline", "end", "right", "middle":
+			return true
+		}
+	}
+	return false
+}
+
+// parseRow parses nodes up to the next token that stops a row, or until,
+// if set. The stopping token is not consumed.
+func (p *parser) parseRow(until rune) ([]node, error) {
+	var nodes []node
+	for {
+		t := p.peek()
+		if stops(t, until) {
+			return nodes, nil
+		}
+		switch {
+		case t.is(tokChar, "^"), t.is(tokChar, "_"), t.is(tokChar, "'"):
+			base := node{xml: "<mrow></mrow>"}
+			if len(nodes) > 0 {
+				base = nodes[len(nodes)-1]
+				nodes = nodes[:len(nodes)-1]
+			}
+			n, err := p.parseScripts(base)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, n)
+			continue
+		case t.is(tokCommand, "limits"), t.is(tokCommand, "nolimits"):
+			p.next()
+			if len(nodes) > 0 {
+				nodes[len(nodes)-1].limits = t.text == "limits"
+			}
+			continue
+		case t.is(tokCommand, "displaystyle"), t.is(tokCommand, "textstyle"):
+			// Style switches apply to the rest of the row.
+			p.next()
+			rest, err := p.parseRow(until)
+			if err != nil {
+				return nil, err
+			}
+			return append(nodes, node{xml: fmt.Sprintf(`<mstyle displaystyle="%t">%s</mstyle>`, t.text == "displaystyle", rowXML(rest))}), nil
+		case t.is(tokCommand, "color"):
+			p.next()
+			color, err := p.readGroup()
+			if err != nil {
+				return nil, err
+			}
+			rest, err := p.parseRow(until)
+			if err != nil {
+				return nil, err
+			}
+			return append(nodes, node{xml: `<mstyle mathcolor="` + html.EscapeString(color) + `">` + rowXML(rest) + `</mstyle>`}), nil
+		}
+		n, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+// rowXML joins nodes, applying function names to what follows them.
+func rowXML(nodes []node) string {
+	var b strings.Builder
+	for i, n := range nodes {
+		b.WriteString(n.xml)
+		if n.fn && i+1 < len(nodes) {
+			b.WriteString("<mo>&#x2061;</mo>")
+		}
+	}
+	return b.String()
+}
+
+// mrow wraps nodes so they form a single element.
+func mrow(nodes []node) string {
+	if len(nodes) == 1 && !nodes[0].fn {
+		return nodes[0].xml
+	}
+	return "<mrow>" + rowXML(nodes) + "</mrow>"
+}
+
+// parseScripts attaches the sub- and superscripts and primes that follow
+// base to it.
+func (p *parser) parseScripts(base node) (node, error) {
+	var sub, sup, primes string
+	for {
+		t := p.peek()
+		switch {
+		case t.is(tokChar, "'"):
+			p.next()
+			primes += "<mo>′</mo>"
+			continue
+		case t.is(tokChar, "^"), t.is(tokChar, "_"):
+			p.next()
+			arg, err := p.parseArg()
+			if err != nil {
+				return node{}, err
+			}
+			if t.text == "^" {
+				if sup != "" {
+					return node{}, fmt.Errorf("double superscript")
+				}
+				sup = arg.xml
+			} else {
+				if sub != "" {
+					return node{}, fmt.Errorf("double subscript")
+				}
+				sub = arg.xml
+			}
+			continue
+		}
+		break
+	}
+	switch {
+	case primes == "":
+	case sup == "" && strings.Count(primes, "<mo>") == 1:
+		sup = primes
+	default:
+		sup = "<mrow>" + primes + sup + "</mrow>"
+	}
+
+	under, over, both := "msub", "msup", "msubsup"
+	if base.limits {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	n := node{fn: base.fn}
+	switch {
+	case sub != "" && sup != "":
+		n.xml = "<" + both + ">" + base.xml + sub + sup + "</" + both + ">"
+	case sub != "":
+		n.xml = "<" + under + ">" + base.xml + sub + "</" + under + ">"
+	default:
+		n.xml = "<" + over + ">" + base.xml + sup + "</" + over + ">"
+	}
+	return n, nil
+}
+
+// parseArg parses a command's argument or a script. As in TeX, an argument
+// that isn't braced is a single token, so a number only supplies its first
+// digit: \frac12 is one half.
+func (p *parser) parseArg() (node, error) {
+	p.skipSpace()
+	if p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+		p.pos++
+		return node{xml: "<mn>" + p.styled(string(p.src[p.pos-1])) + "</mn>"}, nil
+	}
+	return p.parseAtom()
+}
+
+// parseAtom parses a single element: a braced group, a character, a number
+// or a command with its arguments.
+func (p *parser) parseAtom() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokEOF:
+		return node{}, fmt.Errorf("missing argument")
+	case tokNumber:
+		return node{xml: "<mn>" + p.styled(t.text) + "</mn>"}, nil
+	case tokCommand:
+		return p.command(t.text)
+	}
+
+	c := []rune(t.text)[0]
+	switch {
+	case c == '{':
+		row, err := p.parseRow(0)
+		if err != nil {
+			return node{}, err
+		}
+		if !p.next().is(tokChar, "}") {
+			return node{}, fmt.Errorf("missing }")
+		}
+		return node{xml: mrow(row)}, nil
+	case c == '}' || c == '&' || c == '^' || c == '_':
+		return node{}, fmt.Errorf("unexpected %q", t.text)
+	case unicode.IsLetter(c):
+		return p.identifier(t.text), nil
+	case c == '~':
+		return node{xml: `<mspace width="0.3333em"></mspace>`}, nil
+	case c == '-':
+		return mo("−"), nil
+	case c == '*':
+		return mo("∗"), nil
+	}
+	return mo(t.text), nil
+}
+
+func mo(s string) node {
+	return node{xml: "<mo>" + html.EscapeString(s) + "</mo>"}
+}
+
+// identifier renders a single letter in the current alphabet.
+func (p *parser) identifier(s string) node {
+	if p.variant == "normal" {
+		return node{xml: `<mi mathvariant="normal">` + html.EscapeString(s) + "</mi>"}
+	}
+	return node{xml: "<mi>" + html.EscapeString(p.styled(s)) + "</mi>"}
+}
+
+// styled maps the letters and digits of s to the current alphabet.
+func (p *parser) styled(s string) string {
+	if p.variant == "" || p.variant == "normal" {
+		return s
+	}
+	return strings.Map(func(r rune) rune { return styleRune(r, p.variant) }, s)
+}
+
+// fontVariants are the alphabets selected by font commands.
+var fontVariants = map[string]string{
+	"mathbf": "bold", "mathit": "italic", "mathbb": "double-struck",
+	"mathcal": "script", "mathscr": "script", "mathfrak": "fraktur",
+	"mathsf": "sans-serif", "mathtt": "monospace", "mathrm": "normal",
+	"mathup": "normal", "boldsymbol": "bold-italic", "bm": "bold-italic",
+}
+
+// textVariants are the text commands, with the style each applies.
+var textVariants = map[string]string{
+	"text": "", "textrm": "", "textnormal": "", "mbox": "", "hbox": "",
+	"textup": "", "textit": "italic", "textbf": "bold", "textsf": "sans-serif",
+	"texttt": "monospace",
+}
+
+// negations are the precomposed forms of relations negated with \not.
+var negations = map[string]string{
+	"=": "≠", "<": "≮", ">": "≯", "∈": "∉", "≤": "≰", "≥": "≱", "⊂": "⊄",
+	"⊃": "⊅", "⊆": "⊈", "⊇": "⊉", "≡": "≢", "∼": "≁", "≈": "≉", "∣": "∤",
+}
+
+func (p *parser) command(name string) (node, error) {
+	if s, ok := identifiers[name]; ok {
+		if r := []rune(s)[0]; unicode.IsUpper(r) && unicode.In(r, unicode.Greek) {
+			// Capital Greek letters are upright, as in TeX.
+			return node{xml: `<mi mathvariant="normal">` + s + "</mi>"}, nil
+		}
+		return node{xml: "<mi>" + s + "</mi>"}, nil
+	}
+	if s, ok := operators[name]; ok {
+		return mo(s), nil
+	}
+	if s, ok := bigOperators[name]; ok {
+		return node{xml: "<mo>" + s + "</mo>", limits: true}, nil
+	}
+	if s, ok := integrals[name]; ok {
+		return node{xml: "<mo>" + s + "</mo>"}, nil
+	}
+	if functions[name] {
+		text := name
+		if s, ok := functionNames[name]; ok {
+			text = s
+		}
+		return node{xml: "<mi>" + text + "</mi>", fn: true, limits: limitFunctions[name]}, nil
+	}
+	if w, ok := spaces[name]; ok {
+		return node{xml: `<mspace width="` + w + `"></mspace>`}, nil
+	}
+	if size, ok := delimiterSizes[name]; ok {
+		d, err := p.delimiter()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: `<mo minsize="` + size + `" maxsize="` + size + `" stretchy="true" symmetric="true">` + html.EscapeString(d) + "</mo>"}, nil
+	}
+	if v, ok := fontVariants[name]; ok {
+		saved := p.variant
+		p.variant = v
+		n, err := p.parseArg()
+		p.variant = saved
+		return node{xml: n.xml}, err
+	}
+	if v, ok := textVariants[name]; ok {
+		s, err := p.readGroup()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: mtext(s, v)}, nil
+	}
+	if a, ok := accents[name]; ok {
+		arg, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		stretchy := strings.HasPrefix(name, "wide") || strings.HasPrefix(name, "over")
+		return node{xml: fmt.Sprintf(`<mover accent="true">%s<mo stretchy="%t">%s</mo></mover>`, arg.xml, stretchy, html.EscapeString(a))}, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		den, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		frac := "<mfrac>" + num.xml + den.xml + "</mfrac>"
+		switch name {
+		case "dfrac", "cfrac":
+			frac = `<mstyle displaystyle="true">` + frac + "</mstyle>"
+		case "tfrac":
+			frac = `<mstyle displaystyle="false">` + frac + "</mstyle>"
+		}
+		return node{xml: frac}, nil
+	case "binom", "dbinom", "tbinom":
+		n, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		k, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: `<mrow><mo>(</mo><mfrac linethickness="0">` + n.xml + k.xml + `</mfrac><mo>)</mo></mrow>`}, nil
+	case "sqrt":
+		var index []node
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '[' {
+			p.pos++
+			var err error
+			if index, err = p.parseRow(']'); err != nil {
+				return node{}, err
+			}
+			if !p.next().is(tokChar, "]") {
+				return node{}, fmt.Errorf("missing ]")
+			}
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		if len(index) > 0 {
+			return node{xml: "<mroot>" + arg.xml + mrow(index) + "</mroot>"}, nil
+		}
+		return node{xml: "<msqrt>" + arg.xml + "</msqrt>"}, nil
+	case "operatorname":
+		limits := false
+		if p.pos < len(p.src) && p.src[p.pos] == '*' {
+			p.pos++
+			limits = true
+		}
+		s, err := p.readGroup()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: "<mi>" + html.EscapeString(strings.TrimSpace(s)) + "</mi>", fn: true, limits: limits}, nil
+	case "underline":
+		arg, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: `<munder accentunder="true">` + arg.xml + `<mo stretchy="true">_</mo></munder>`}, nil
+	case "overbrace", "underbrace":
+		arg, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		if name == "overbrace" {
+			return node{xml: `<mover>` + arg.xml + `<mo stretchy="true">⏞</mo></mover>`, limits: true}, nil
+		}
+		return node{xml: `<munder>` + arg.xml + `<mo stretchy="true">⏟</mo></munder>`, limits: true}, nil
+	case "overset", "stackrel", "underset":
+		over, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		base, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		if name == "underset" {
+			return node{xml: "<munder>" + base.xml + over.xml + "</munder>"}, nil
+		}
+		return node{xml: "<mover>" + base.xml + over.xml + "</mover>"}, nil
+	case "textcolor":
+		color, err := p.readGroup()
+		if err != nil {
+			return node{}, err
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: `<mstyle mathcolor="` + html.EscapeString(color) + `">` + arg.xml + `</mstyle>`}, nil
+	case "phantom":
+		arg, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		return node{xml: "<mphantom>" + arg.xml + "</mphantom>"}, nil
+	case "not":
+		arg, err := p.parseArg()
+		if err != nil {
+			return node{}, err
+		}
+		if s, ok := strings.CutPrefix(arg.xml, "<mo>"); ok {
+			s = strings.TrimSuffix(s, "</mo>")
+			if neg, ok := negations[html.UnescapeString(s)]; ok {
+				return mo(neg), nil
+			}
+			return node{xml: "<mo>" + s + "\u0338</mo>"}, nil
+		}
+		return arg, nil
+	case "left":
+		return p.parseFenced()
+	case "begin":
+		return p.parseEnvironment()
+	}
+	return node{xml: `<merror><mtext>\` + html.EscapeString(name) + `</mtext></merror>`}, nil
+}
+
+// mtext renders text from \text and friends. Spaces at the ends are kept,
+// which MathML would otherwise trim.
+func mtext(s, variant string) string {
+	s = strings.NewReplacer(`\{`, "{", `\}`, "}", `\%`, "%", `\&`, "&", `\_`, "_", `\$`, "$", `\#`, "#", `\ `, " ", "~", " ").Replace(s)
+	if strings.HasPrefix(s, " ") {
+		s = " " + s[1:]
+	}
+	if strings.HasSuffix(s, " ") {
+		s = s[:len(s)-1] + " "
+	}
+	if variant != "" {
+		return `<mtext mathvariant="` + variant + `">` + html.EscapeString(s) + "</mtext>"
+	}
+	return "<mtext>" + html.EscapeString(s) + "</mtext>"
+}
+
+// readGroup returns the raw contents of the braced group that follows.
+func (p *parser) readGroup() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '{' {
+		return "", fmt.Errorf("missing {")
+	}
+	start, depth := p.pos+1, 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return string(p.src[start : p.pos-1]), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("missing }")
+}
+
+// delimiter reads the delimiter after \left, \right, \middle or \big. An
+// empty string is the invisible delimiter '.'.
+func (p *parser) delimiter() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokChar:
+		switch t.text {
+		case ".":
+			return "", nil
+		case "<":
+			return "⟨", nil
+		case ">":
+			return "⟩", nil
+		case "(", ")", "[", "]", "|", "/":
+			return t.text, nil
+		}
+	case tokCommand:
+		if s, ok := operators[t.text]; ok {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("missing delimiter")
+}
+
+func fence(d string) string {
+	if d == "" {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true" symmetric="true">` + html.EscapeString(d) + "</mo>"
+}
+
+// parseFenced parses a group between \left and \right, with any \middle
+// delimiters in it.
+func (p *parser) parseFenced() (node, error) {
+	open, err := p.delimiter()
+	if err != nil {
+		return node{}, err
+	}
+	xml := fence(open)
+	for {
+		row, err := p.parseRow(0)
+		if err != nil {
+			return node{}, err
+		}
+		xml += rowXML(row)
+		t := p.next()
+		switch {
+		case t.is(tokCommand, "middle"):
+			d, err := p.delimiter()
+			if err != nil {
+				return node{}, err
+			}
+			xml += fence(d)
+		case t.is(tokCommand, "right"):
+			d, err := p.delimiter()
+			if err != nil {
+				return node{}, err
+			}
+			return node{xml: "<mrow>" + xml + fence(d) + "</mrow>"}, nil
+		default:
+			return node{}, fmt.Errorf(`missing \right`)
+		}
+	}
+}
+
+// parseEnvironment parses \begin{env} ... \end{env} as a table.
+func (p *parser) parseEnvironment() (node, error) {
+	env, err := p.readGroup()
+	if err != nil {
+		return node{}, err
+	}
+	fences, ok := matrixFences[env]
+	if !ok {
+		return node{}, fmt.Errorf("unknown environment %s", env)
+	}
+	if env == "array" || strings.HasPrefix(env, "alignat") {
+		// Column specs and counts don't change the rendering.
+		if _, err := p.readGroup(); err != nil {
+			return node{}, err
+		}
+	}
+
+	var rows [][]string
+	var cells []string
+	for {
+		row, err := p.parseRow(0)
+		if err != nil {
+			return node{}, err
+		}
+		cells = append(cells, rowXML(row))
+		t := p.next()
+		switch {
+		case t.is(tokChar, "&"):
+			continue
+		case t.is(tokCommand, `\`), t.is(tokCommand, "cr"):
+			rows, cells = append(rows, cells), nil
+			continue
+		case t.is(tokCommand, "end"):
+			end, err := p.readGroup()
+			if err != nil {
+				return node{}, err
+			}
+			if end != env {
+				return node{}, fmt.Errorf(`\begin{%s} ended by \end{%s}`, env, end)
+			}
+		default:
+			return node{}, fmt.Errorf(`missing \end{%s}`, env)
+		}
+		break
+	}
+	if len(cells) > 1 || cells[0] != "" {
+		rows = append(rows, cells)
+	}
+
+	aligned := strings.HasPrefix(env, "align") || env == "split"
+	var b strings.Builder
+	b.WriteString(fence(fences[0]))
+	switch {
+	case aligned || strings.HasPrefix(env, "gather") || strings.HasPrefix(env, "equation"):
+		b.WriteString(`<mtable displaystyle="true">`)
+	default:
+		b.WriteString("<mtable>")
+	}
+	for _, row := range rows {
+		b.WriteString("<mtr>")
+		for i, cell := range row {
+			switch {
+			case aligned && i%2 == 0:
+				b.WriteString(`<mtd style="text-align: right; padding-right: 0">`)
+			case aligned:
+				b.WriteString(`<mtd style="text-align: left; padding-left: 0">`)
+			case env == "cases" || env == "rcases":
+				b.WriteString(`<mtd style="text-align: left">`)
+			default:
+				b.WriteString("<mtd>")
+			}
+			b.WriteString(cell + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	b.WriteString(fence(fences[1]))
+	if fences[0] == "" && fences[1] == "" {
+		return node{xml: b.String()}, nil
+	}
+	return node{xml: "<mrow>" + b.String() + "</mrow>"}, nil
+}
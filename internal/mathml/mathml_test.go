@@ -0,0 +1,99 @@
+package mathml
+
+import (
+	"strings"
+	"testing"
+)
+
+// body strips the <math> wrapper and annotation Convert adds around the
+// rendered formula.
+func body(t *testing.T, out string) string {
+	t.Helper()
+	const head = `<math><semantics><mrow>`
+	end := strings.Index(out, `</mrow><annotation`)
+	if !strings.HasPrefix(out, head) || end < 0 {
+		t.Fatalf("unexpected wrapper: %s", out)
+	}
+	return out[len(head):end]
+}
+
+func TestConvert(t *testing.T) {
+	fenced := func(open, inner, close string) string {
+		const mo = `<mo fence="true" stretchy="true" symmetric="true">`
+		s := `<mrow>` + mo + open + `</mo>` + inner
+		if close != "" {
+			s += mo + close + `</mo>`
+		}
+		return s + `</mrow>`
+	}
+	tests := []struct {
+		tex  string
+		want string
+	}{
+		{`x`, `<mi>x</mi>`},
+		{`12.5`, `<mn>12.5</mn>`},
+		{`a<b`, `<mi>a</mi><mo>&lt;</mo><mi>b</mi>`},
+		{`x^2`, `<msup><mi>x</mi><mn>2</mn></msup>`},
+		{`x_i^2`, `<msubsup><mi>x</mi><mi>i</mi><mn>2</mn></msubsup>`},
+		{`f'(x)`, `<msup><mi>f</mi><mo>′</mo></msup><mo>(</mo><mi>x</mi><mo>)</mo>`},
+		{`\frac{a}{b}`, `<mfrac><mi>a</mi><mi>b</mi></mfrac>`},
+		{`\sqrt{x}`, `<msqrt><mi>x</mi></msqrt>`},
+		{`\sqrt[3]{x}`, `<mroot><mi>x</mi><mn>3</mn></mroot>`},
+		{`\alpha+\beta`, `<mi>α</mi><mo>+</mo><mi>β</mi>`},
+		{`\mathbb{R}`, `<mi>ℝ</mi>`},
+		{`\text{if } x`, "<mtext>if\u00a0</mtext><mi>x</mi>"}, // edge spaces kept as no-break spaces
+		{`\hat{x}`, `<mover accent="true"><mi>x</mi><mo stretchy="false">^</mo></mover>`},
+		{`\sum_{i=1}^n i`, `<munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover><mi>i</mi>`},
+		{`\lim_{x\to 0}`, `<munder><mi>lim</mi><mrow><mi>x</mi><mo>→</mo><mn>0</mn></mrow></munder>`},
+		{`\left( x \right)`, fenced("(", `<mi>x</mi>`, ")")},
+		{`\begin{pmatrix} a & b \\ c & d \end{pmatrix}`, fenced("(", `<mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable>`, ")")},
+		{`\begin{cases} 1 & x>0 \\ 0 & \text{else} \end{cases}`, fenced("{", `<mtable><mtr><mtd style="text-align: left"><mn>1</mn></mtd><mtd style="text-align: left"><mi>x</mi><mo>&gt;</mo><mn>0</mn></mtd></mtr><mtr><mtd style="text-align: left"><mn>0</mn></mtd><mtd style="text-align: left"><mtext>else</mtext></mtd></mtr></mtable>`, "")},
+
+		// Unsupported commands are shown as errors in place.
+		{`\foo`, `<merror><mtext>\foo</mtext></merror>`},
+		{`a \over b`, `<mi>a</mi><merror><mtext>\over</mtext></merror><mi>b</mi>`},
+	}
+	for _, tt := range tests {
+		out, err := Convert(tt.tex, false)
+		if err != nil {
+			t.Errorf("Convert(%q): %v", tt.tex, err)
+			continue
+		}
+		if got := body(t, out); got != tt.want {
+			t.Errorf("Convert(%q)\n got %s\nwant %s", tt.tex, got, tt.want)
+		}
+	}
+}
+
+func TestConvertMalformed(t *testing.T) {
+	tests := []struct {
+		tex string
+		err string
+	}{
+		{`{x`, `missing }`},
+		{`x}`, `unexpected "}"`},
+		{`\frac{a}`, `missing argument`},
+		{`x^`, `missing argument`},
+		{`\left( x`, `missing \right`},
+		{`\begin{matrix} a`, `missing \end{matrix}`},
+	}
+	for _, tt := range tests {
+		_, err := Convert(tt.tex, false)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Convert(%q) error = %v, want %q", tt.tex, err, tt.err)
+		}
+	}
+}
+
+func TestConvertDisplay(t *testing.T) {
+	out, err := Convert(` a<b `, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, `<math display="block">`) {
+		t.Errorf("display math not a block: %s", out)
+	}
+	if !strings.Contains(out, `<annotation encoding="application/x-tex">a&lt;b</annotation>`) {
+		t.Errorf("source annotation missing or unescaped: %s", out)
+	}
+}
@@ -0,0 +1,120 @@
+package mathml
+
+// identifiers are commands rendered as <mi>: Greek letters and other
+// symbols that act as variables or constants.
+var identifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ",
+	"varepsilon": "ε", "zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ",
+	"iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ",
+	"omicron": "ο", "pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ",
+	"sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+	"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ",
+	"Pi": "Π", "Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅",
+	"hbar": "ℏ", "ell": "ℓ", "Re": "ℜ", "Im": "ℑ", "aleph": "ℵ", "wp": "℘",
+	"imath": "ı", "jmath": "ȷ", "top": "⊤", "bot": "⊥", "angle": "∠",
+	"triangle": "△", "square": "□", "checkmark": "✓", "dagger": "†",
+	"prime": "′", "degree": "°",
+}
+
+// operators are commands rendered as <mo>: binary operators, relations,
+// arrows, delimiters and punctuation.
+var operators = map[string]string{
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗",
+	"star": "⋆", "circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖",
+	"otimes": "⊗", "odot": "⊙", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨",
+	"cap": "∩", "cup": "∪", "setminus": "∖", "neg": "¬", "lnot": "¬",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠",
+	"ll": "≪", "gg": "≫", "approx": "≈", "sim": "∼", "simeq": "≃", "cong": "≅",
+	"equiv": "≡", "propto": "∝", "doteq": "≐", "prec": "≺", "succ": "≻",
+	"preceq": "⪯", "succeq": "⪰", "in": "∈", "notin": "∉", "ni": "∋",
+	"subset": "⊂", "supset": "⊃", "subseteq": "⊆", "supseteq": "⊇",
+	"subsetneq": "⊊", "supsetneq": "⊋", "perp": "⊥", "parallel": "∥",
+	"mid": "∣", "nmid": "∤", "vdash": "⊢", "dashv": "⊣", "models": "⊨",
+	"forall": "∀", "exists": "∃", "nexists": "∄", "therefore": "∴", "because": "∵",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "implies": "⟹", "impliedby": "⟸", "iff": "⟺",
+	"longrightarrow": "⟶", "longleftarrow": "⟵", "Longrightarrow": "⟹",
+	"Longleftarrow": "⟸", "mapsto": "↦", "longmapsto": "⟼", "uparrow": "↑",
+	"downarrow": "↓", "updownarrow": "↕", "Uparrow": "⇑", "Downarrow": "⇓",
+	"hookrightarrow": "↪", "hookleftarrow": "↩", "rightharpoonup": "⇀",
+	"nearrow": "↗", "searrow": "↘", "swarrow": "↙", "nwarrow": "↖",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉", "lvert": "|", "rvert": "|", "vert": "|",
+	"lVert": "‖", "rVert": "‖", "Vert": "‖", "|": "‖", "{": "{", "}": "}",
+	"lbrace": "{", "rbrace": "}", "backslash": "∖",
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"colon": ":", "#": "#", "%": "%", "&": "&", "$": "$", "_": "_",
+}
+
+// bigOperators take limits above and below in display style.
+var bigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂",
+	"bigvee": "⋁", "bigwedge": "⋀", "bigoplus": "⨁", "bigotimes": "⨂",
+	"bigodot": "⨀", "biguplus": "⨄", "bigsqcup": "⨆",
+}
+
+// integrals take limits as sub- and superscripts.
+var integrals = map[string]string{
+	"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+}
+
+// functions are upright function names. Those in limitFunctions take limits
+// like big operators.
+var functions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true,
+	"tanh": true, "coth": true, "log": true, "ln": true, "lg": true, "exp": true,
+	"det": true, "dim": true, "ker": true, "deg": true, "arg": true, "gcd": true,
+	"hom": true, "Pr": true,
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true,
+	"sup": true, "inf": true, "argmax": true, "argmin": true,
+}
+
+var limitFunctions = map[string]bool{
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true,
+	"sup": true, "inf": true, "argmax": true, "argmin": true, "det": true,
+	"gcd": true, "Pr": true,
+}
+
+// functionNames overrides how some function names are written.
+var functionNames = map[string]string{
+	"liminf": "lim inf", "limsup": "lim sup", "argmax": "arg max", "argmin": "arg min",
+}
+
+// accents are placed over (or, for underline, under) their argument.
+var accents = map[string]string{
+	"hat": "^", "widehat": "^", "check": "ˇ", "tilde": "~", "widetilde": "~",
+	"bar": "‾", "overline": "‾", "vec": "→", "overrightarrow": "→",
+	"overleftarrow": "←", "dot": "˙", "ddot": "¨", "acute": "´", "grave": "`",
+	"breve": "˘", "mathring": "˚",
+}
+
+// spaces are the widths of spacing commands.
+var spaces = map[string]string{
+	",": "0.1667em", "thinspace": "0.1667em", ":": "0.2222em", ">": "0.2222em",
+	"medspace": "0.2222em", ";": "0.2778em", "thickspace": "0.2778em",
+	" ": "0.3333em", "quad": "1em", "qquad": "2em", "!": "-0.1667em",
+	"negthinspace": "-0.1667em",
+}
+
+// delimiterSizes are the heights of delimiters sized with \big and friends.
+var delimiterSizes = map[string]string{
+	"big": "1.2em", "bigl": "1.2em", "bigr": "1.2em", "bigm": "1.2em",
+	"Big": "1.623em", "Bigl": "1.623em", "Bigr": "1.623em", "Bigm": "1.623em",
+	"bigg": "2.047em", "biggl": "2.047em", "biggr": "2.047em", "biggm": "2.047em",
+	"Bigg": "2.470em", "Biggl": "2.470em", "Biggr": "2.470em", "Biggm": "2.470em",
+}
+
+// matrixFences are the delimiters around each matrix environment.
+var matrixFences = map[string][2]string{
+	"matrix": {"", ""}, "smallmatrix": {"", ""}, "pmatrix": {"(", ")"},
+	"bmatrix": {"[", "]"}, "Bmatrix": {"{", "}"}, "vmatrix": {"|", "|"},
+	"Vmatrix": {"‖", "‖"}, "cases": {"{", ""}, "rcases": {"", "}"},
+	"array": {"", ""}, "aligned": {"", ""}, "align": {"", ""}, "align*": {"", ""},
+	"alignat": {"", ""}, "alignat*": {"", ""}, "split": {"", ""},
+	"gathered": {"", ""}, "gather": {"", ""}, "gather*": {"", ""},
+	"equation": {"", ""}, "equation*": {"", ""},
+}
@@ -0,0 +1,52 @@
+package mdext
+
+import (
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// anchorRenderer renders headings with a link to themselves at the end,
+// shown on hover (see .heading-anchor in style.css).
+type anchorRenderer struct{}
+
+func (r *anchorRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindHeading, r.renderHeading)
+}
+
+func (r *anchorRenderer) renderHeading(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.Heading)
+	level := strconv.Itoa(n.Level)
+	if entering {
+		w.WriteString("<h" + level)
+		if n.Attributes() != nil {
+			html.RenderAttributes(w, node, html.HeadingAttributeFilter)
+		}
+		w.WriteByte('>')
+		return ast.WalkContinue, nil
+	}
+	if id, ok := n.AttributeString("id"); ok {
+		if b, ok := id.([]byte); ok {
+			w.WriteString(`<a class="heading-anchor" href="#`)
+			w.Write(util.EscapeHTML(util.URLEscape(b, false)))
+			w.WriteString(`" aria-label="Link to this section">#</a>`)
+		}
+	}
+	w.WriteString("</h" + level + ">\n")
+	return ast.WalkContinue, nil
+}
+
+type headingAnchors struct{}
+
+// HeadingAnchors adds a self-link to every heading that has an id.
+var HeadingAnchors goldmark.Extender = &headingAnchors{}
+
+func (e *headingAnchors) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&anchorRenderer{}, 500),
+	))
+}
@@ -0,0 +1,164 @@
+package mdext
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindCalloutTitle is the node kind of CalloutTitle.
+var KindCalloutTitle = ast.NewNodeKind("CalloutTitle")
+
+// CalloutTitle is the first line of a callout, "> [!type]- Title". It is the
+// first child of the blockquote it turns into a callout.
+type CalloutTitle struct {
+	ast.BaseBlock
+	Callout string // lowercased, e.g. "note" or "warning"
+	Fold    byte   // '-' starts collapsed, '+' starts expanded, 0 can't collapse
+}
+
+func (n *CalloutTitle) Kind() ast.NodeKind {
+	return KindCalloutTitle
+}
+
+func (n *CalloutTitle) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Callout": n.Callout, "Fold": string(n.Fold)}, nil)
+}
+
+// calloutOf returns the title of the callout a blockquote is, or nil if it
+// is an ordinary blockquote.
+func calloutOf(n ast.Node) *CalloutTitle {
+	t, _ := n.FirstChild().(*CalloutTitle)
+	return t
+}
+
+var calloutRe = regexp.MustCompile(`^\[!([\w-]+)\]([+-]?)`)
+
+// calloutTransformer looks for "[!type]" at the start of a blockquote's first
+// paragraph and splits that line off as the callout's title.
+type calloutTransformer struct{}
+
+func (t *calloutTransformer) Transform(node *ast.Paragraph, reader text.Reader, pc parser.Context) {
+	quote, ok := node.Parent().(*ast.Blockquote)
+	if !ok || quote.FirstChild() != node || node.Lines().Len() == 0 {
+		return
+	}
+	lines := node.Lines()
+	first := lines.At(0)
+	first = first.TrimLeftSpace(reader.Source())
+	m := calloutRe.FindSubmatch(first.Value(reader.Source()))
+	if m == nil {
+		return
+	}
+
+	title := &CalloutTitle{Callout: strings.ToLower(string(m[1]))}
+	if len(m[2]) > 0 {
+		title.Fold = m[2][0]
+	}
+	seg := first.WithStart(first.Start + len(m[0]))
+	seg = seg.TrimLeftSpace(reader.Source())
+	seg = seg.TrimRightSpace(reader.Source())
+	if seg.Len() > 0 {
+		title.Lines().Append(seg)
+	}
+	quote.InsertBefore(quote, node, title)
+
+	rest := text.NewSegments()
+	for i := 1; i < lines.Len(); i++ {
+		rest.Append(lines.At(i))
+	}
+	if rest.Len() == 0 {
+		quote.RemoveChild(quote, node)
+		return
+	}
+	node.SetLines(rest)
+}
+
+// calloutRenderer renders callouts, and the blockquotes that aren't
+// callouts as goldmark does. Collapsible callouts become <details>.
+type calloutRenderer struct{}
+
+func (r *calloutRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindBlockquote, r.renderBlockquote)
+	reg.Register(KindCalloutTitle, r.renderCalloutTitle)
+}
+
+func (r *calloutRenderer) renderBlockquote(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	title := calloutOf(n)
+	switch {
+	case title == nil && entering:
+		if n.Attributes() != nil {
+			w.WriteString("<blockquote")
+			html.RenderAttributes(w, n, html.BlockquoteAttributeFilter)
+			w.WriteByte('>')
+		} else {
+			w.WriteString("<blockquote>\n")
+		}
+	case title == nil:
+		w.WriteString("</blockquote>\n")
+	case entering:
+		tag := "div"
+		if title.Fold != 0 {
+			tag = "details"
+		}
+		w.WriteString(`<` + tag + ` class="callout" data-callout="`)
+		w.Write(util.EscapeHTML([]byte(title.Callout)))
+		w.WriteByte('"')
+		if title.Fold == '+' {
+			w.WriteString(" open")
+		}
+		w.WriteString(">\n")
+	default:
+		w.WriteString("</div>\n")
+		if title.Fold != 0 {
+			w.WriteString("</details>\n")
+		} else {
+			w.WriteString("</div>\n")
+		}
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *calloutRenderer) renderCalloutTitle(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	title := n.(*CalloutTitle)
+	tag := "div"
+	if title.Fold != 0 {
+		tag = "summary"
+	}
+	if entering {
+		w.WriteString(`<` + tag + ` class="callout-title">`)
+		if !n.HasChildren() {
+			// Without a title, the type is the title: "[!tip]" reads "Tip".
+			w.Write(util.EscapeHTML([]byte(strings.ToUpper(title.Callout[:1]) + strings.ReplaceAll(title.Callout[1:], "-", " "))))
+		}
+	} else {
+		w.WriteString(`</` + tag + ">\n" + `<div class="callout-content">` + "\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+type callout struct{}
+
+// Callouts renders Obsidian callouts: blockquotes starting with "[!type]",
+// optionally followed by '-' (collapsed) or '+' (expanded) to make them
+// collapsible, and a title.
+//
+//	> [!warning]- Read this first
+//	> Callout content.
+var Callouts goldmark.Extender = &callout{}
+
+func (e *callout) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithParagraphTransformers(
+		util.Prioritized(&calloutTransformer{}, 150),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&calloutRenderer{}, 500),
+	))
+}
@@ -0,0 +1,122 @@
+package mdext
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// codeOptions are the settings in a fenced code block's info string after
+// the language:
+//
+//	```go {1,3-5} linenos
+//
+// highlights lines 1 and 3 to 5 and numbers the lines.
+type codeOptions struct {
+	lang        string
+	lines       [][2]int
+	lineNumbers bool
+}
+
+var lineRangesRe = regexp.MustCompile(`\{([\d\s,-]*)\}`)
+
+func parseCodeInfo(info string) codeOptions {
+	var opts codeOptions
+	if m := lineRangesRe.FindStringSubmatch(info); m != nil {
+		info = strings.Replace(info, m[0], " ", 1)
+		for _, r := range strings.Split(m[1], ",") {
+			from, to, isRange := strings.Cut(strings.TrimSpace(r), "-")
+			a, err := strconv.Atoi(strings.TrimSpace(from))
+			if err != nil {
+				continue
+			}
+			b := a
+			if isRange {
+				if b, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || b < a {
+					continue
+				}
+			}
+			opts.lines = append(opts.lines, [2]int{a, b})
+		}
+	}
+	for i, f := range strings.Fields(info) {
+		switch {
+		case f == "linenos":
+			opts.lineNumbers = true
+		case i == 0:
+			opts.lang = strings.ToLower(f)
+		}
+	}
+	return opts
+}
+
+// codeRenderer highlights fenced code blocks with chroma. Tokens get CSS
+// classes (styled in style.css) rather than inline colors.
+type codeRenderer struct{}
+
+func (r *codeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	node := n.(*ast.FencedCodeBlock)
+
+	var opts codeOptions
+	if node.Info != nil {
+		opts = parseCodeInfo(string(node.Info.Segment.Value(source)))
+	}
+	var code strings.Builder
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+
+	lexer := lexers.Get(opts.lang)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		// Fall back to a plain block rather than failing the page.
+		w.WriteString("<pre><code>")
+		w.Write(util.EscapeHTML([]byte(code.String())))
+		w.WriteString("</code></pre>\n")
+		return ast.WalkSkipChildren, nil
+	}
+
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(opts.lineNumbers),
+		chromahtml.HighlightLines(opts.lines),
+	)
+	if err := formatter.Format(w, styles.Fallback, tokens); err != nil {
+		return ast.WalkStop, err
+	}
+	w.WriteByte('\n')
+	return ast.WalkSkipChildren, nil
+}
+
+type codeHighlighting struct{}
+
+// CodeHighlighting highlights fenced code blocks by language, with optional
+// line numbers and highlighted lines.
+var CodeHighlighting goldmark.Extender = &codeHighlighting{}
+
+func (e *codeHighlighting) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&codeRenderer{}, 500),
+	))
+}
@@ -0,0 +1,104 @@
+// Package mdext provides goldmark extensions for the markdown notes are
+// written in: Obsidian callouts and ==highlights==, LaTeX math rendered to
+// MathML, server-side syntax highlighting for fenced code, and self-links on
+// headings.
+package mdext
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindHighlight is the node kind of Highlight.
+var KindHighlight = ast.NewNodeKind("Highlight")
+
+// Highlight is ==highlighted text==, rendered as <mark>.
+type Highlight struct {
+	ast.BaseInline
+}
+
+func (n *Highlight) Kind() ast.NodeKind {
+	return KindHighlight
+}
+
+func (n *Highlight) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type highlightDelimiterProcessor struct{}
+
+func (p *highlightDelimiterProcessor) IsDelimiter(b byte) bool {
+	return b == '='
+}
+
+func (p *highlightDelimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char
+}
+
+func (p *highlightDelimiterProcessor) OnMatch(consumes int) ast.Node {
+	return &Highlight{}
+}
+
+var defaultHighlightDelimiterProcessor = &highlightDelimiterProcessor{}
+
+type highlightParser struct{}
+
+func (s *highlightParser) Trigger() []byte {
+	return []byte{'='}
+}
+
+// Parse accepts exactly two '=' as a delimiter, so "a = b" and "===" are
+// left alone.
+func (s *highlightParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	node := parser.ScanDelimiter(line, before, 2, defaultHighlightDelimiterProcessor)
+	if node == nil || node.OriginalLength != 2 || before == '=' {
+		return nil
+	}
+
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+func (s *highlightParser) CloseBlock(parent ast.Node, pc parser.Context) {}
+
+type highlightRenderer struct{}
+
+func (r *highlightRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindHighlight, r.renderHighlight)
+}
+
+func (r *highlightRenderer) renderHighlight(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		w.WriteString("<mark")
+		if n.Attributes() != nil {
+			html.RenderAttributes(w, n, html.GlobalAttributeFilter)
+		}
+		w.WriteByte('>')
+	} else {
+		w.WriteString("</mark>")
+	}
+	return ast.WalkContinue, nil
+}
+
+type highlight struct{}
+
+// Highlights renders ==text== as <mark>text</mark>.
+var Highlights goldmark.Extender = &highlight{}
+
+func (e *highlight) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&highlightParser{}, 500),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&highlightRenderer{}, 500),
+	))
+}
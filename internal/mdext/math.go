@@ -0,0 +1,235 @@
+package mdext
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/nilszeilon/notesync/internal/mathml"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindInlineMath is the node kind of InlineMath.
+var KindInlineMath = ast.NewNodeKind("InlineMath")
+
+// InlineMath is $math$ within a paragraph, or $$math$$ when Display is set.
+// Its children are the raw TeX.
+type InlineMath struct {
+	ast.BaseInline
+	Display bool
+}
+
+func (n *InlineMath) Kind() ast.NodeKind {
+	return KindInlineMath
+}
+
+func (n *InlineMath) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// KindMathBlock is the node kind of MathBlock.
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// MathBlock is display math in a block of its own, between lines starting
+// and ending with $$. Its lines are the raw TeX.
+type MathBlock struct {
+	ast.BaseBlock
+	closed bool // the closing $$ has been read
+}
+
+func (n *MathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	start := pos + 2
+	rest := util.TrimRightSpace(line[start:])
+	node := &MathBlock{}
+	if end := bytes.Index(rest, []byte("$$")); end >= 0 {
+		// $$math$$ on one line is a block only if nothing follows it.
+		if len(bytes.TrimSpace(rest[end+2:])) > 0 || end == 0 {
+			return nil, parser.NoChildren
+		}
+		node.Lines().Append(text.NewSegment(segment.Start+start, segment.Start+start+end))
+		node.closed = true
+		reader.AdvanceToEOL()
+		return node, parser.NoChildren
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		node.Lines().Append(text.NewSegment(segment.Start+start, segment.Start+start+len(rest)))
+	}
+	reader.AdvanceToEOL()
+	return node, parser.NoChildren
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*MathBlock).closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	trimmed := util.TrimRightSpace(line)
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		if content := trimmed[:len(trimmed)-2]; len(bytes.TrimSpace(content)) > 0 {
+			node.Lines().Append(text.NewSegment(segment.Start, segment.Start+len(content)))
+		}
+		reader.AdvanceToEOL()
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	reader.AdvanceToEOL()
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type mathInlineParser struct{}
+
+func (s *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse reads $math$ and $$math$$. To leave prices alone, the opening '$'
+// must be followed by a non-space and the closing '$' must follow one, and
+// neither may touch a letter or digit on the outside, so "$5 and $10" and
+// "US$5" stay text.
+func (s *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	if bytes.HasPrefix(line, []byte("$$")) {
+		end := bytes.Index(line[2:], []byte("$$"))
+		if end <= 0 {
+			return nil
+		}
+		node := &InlineMath{Display: true}
+		node.AppendChild(node, ast.NewRawTextSegment(text.NewSegment(segment.Start+2, segment.Start+2+end)))
+		block.Advance(end + 4)
+		return node
+	}
+
+	if len(line) < 2 || util.IsSpace(line[1]) || isAlnum(block.PrecendingCharacter()) {
+		return nil
+	}
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '$':
+			if util.IsSpace(line[i-1]) || i+1 < len(line) && isAlnum(rune(line[i+1])) {
+				return nil
+			}
+			node := &InlineMath{}
+			node.AppendChild(node, ast.NewRawTextSegment(text.NewSegment(segment.Start+1, segment.Start+i)))
+			block.Advance(i + 1)
+			return node
+		}
+	}
+	return nil
+}
+
+func isAlnum(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindInlineMath, r.renderInlineMath)
+	reg.Register(KindMathBlock, r.renderMathBlock)
+}
+
+func (r *mathRenderer) renderInlineMath(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var tex strings.Builder
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		tex.Write(c.(*ast.Text).Segment.Value(source))
+	}
+	display := n.(*InlineMath).Display
+	out, err := mathml.Convert(tex.String(), display)
+	if err != nil {
+		delim := "$"
+		if display {
+			delim = "$$"
+		}
+		w.WriteString(`<code class="math-error" title="`)
+		w.Write(util.EscapeHTML([]byte(err.Error())))
+		w.WriteString(`">`)
+		w.Write(util.EscapeHTML([]byte(delim + tex.String() + delim)))
+		w.WriteString("</code>")
+		return ast.WalkSkipChildren, nil
+	}
+	w.WriteString(out)
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderMathBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var tex strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		tex.Write(bytes.TrimRight(line.Value(source), "\r\n"))
+		tex.WriteByte('\n')
+	}
+	out, err := mathml.Convert(tex.String(), true)
+	if err != nil {
+		// Show the source rather than failing the page.
+		w.WriteString(`<pre class="math-error" title="`)
+		w.Write(util.EscapeHTML([]byte(err.Error())))
+		w.WriteString(`"><code>`)
+		w.Write(util.EscapeHTML([]byte(tex.String())))
+		w.WriteString("</code></pre>\n")
+		return ast.WalkSkipChildren, nil
+	}
+	w.WriteString(out)
+	w.WriteByte('\n')
+	return ast.WalkSkipChildren, nil
+}
+
+type math struct{}
+
+// Math renders $inline$ and $$display$$ LaTeX math as MathML.
+var Math goldmark.Extender = &math{}
+
+func (e *math) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 650)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 500)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&mathRenderer{}, 500),
+	))
+}
@@ -0,0 +1,469 @@
+package search
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+	"gopkg.in/yaml.v3"
+)
+
+// Index is an in-memory full-text index over the notes in a vault. It is
+// updated one file at a time as notes change and is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string][]int // term -> path -> indexes into doc.tokens
+	totalLen int
+}
+
+type document struct {
+	title  string
+	text   string // title, a blank line, then the body
+	tokens []Token
+	tags   []string
+	fields map[string][]string // lowercased frontmatter key -> values
+}
+
+// Result is a single search hit.
+type Result struct {
+	Path     string   `json:"path"`
+	Title    string   `json:"title"`
+	Score    float64  `json:"score"`
+	Snippets []string `json:"snippets"` // HTML-escaped, matches wrapped in <mark>
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string][]int),
+	}
+}
+
+// Len returns the number of indexed notes.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Update (re)indexes the markdown note at relPath.
+func (ix *Index) Update(relPath string, content []byte) {
+	doc := parseDocument(relPath, string(content))
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(relPath)
+	ix.docs[relPath] = doc
+	ix.totalLen += len(doc.tokens)
+	for i, t := range doc.tokens {
+		docs := ix.postings[t.Term]
+		if docs == nil {
+			docs = make(map[string][]int)
+			ix.postings[t.Term] = docs
+		}
+		docs[relPath] = append(docs[relPath], i)
+	}
+}
+
+// Remove drops the note at relPath from the index.
+func (ix *Index) Remove(relPath string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(relPath)
+}
+
+func (ix *Index) remove(relPath string) {
+	doc, ok := ix.docs[relPath]
+	if !ok {
+		return
+	}
+	for _, t := range doc.tokens {
+		if docs := ix.postings[t.Term]; docs != nil {
+			delete(docs, relPath)
+			if len(docs) == 0 {
+				delete(ix.postings, t.Term)
+			}
+		}
+	}
+	ix.totalLen -= len(doc.tokens)
+	delete(ix.docs, relPath)
+}
+
+func parseDocument(relPath, content string) *document {
+	doc := &document{fields: make(map[string][]string)}
+
+	fmBlock, body, ok := markdown.SplitFrontmatter(content)
+	var fm markdown.Frontmatter
+	if ok {
+		_ = yaml.Unmarshal([]byte(fmBlock), &fm)
+		var raw map[string]any
+		if yaml.Unmarshal([]byte(fmBlock), &raw) == nil {
+			for k, v := range raw {
+				doc.fields[strings.ToLower(k)] = fieldValues(v)
+			}
+		}
+	}
+
+	doc.title = fm.Title
+	if doc.title == "" {
+		doc.title = strings.TrimSuffix(path.Base(relPath), path.Ext(relPath))
+	}
+	doc.tags = markdown.MergeTags(fm.Tags, markdown.ExtractTags(body))
+	doc.text = doc.title + "\n\n" + body
+	doc.tokens = Tokenize(doc.text)
+	return doc
+}
+
+func fieldValues(v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		var out []string
+		for _, item := range v {
+			out = append(out, fieldValues(item)...)
+		}
+		return out
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// query is a parsed search query. Plain words and quoted phrases must all
+// match; tag:, path: and other field:value filters narrow the candidates.
+type query struct {
+	terms   []string  // stemmed words
+	phrases [][]Token // tokenized "quoted phrases"
+	tags    []string
+	paths   []string
+	fields  map[string][]string
+}
+
+func parseQuery(q string) query {
+	pq := query{fields: make(map[string][]string)}
+	for _, part := range splitQuery(q) {
+		if strings.HasPrefix(part, `"`) {
+			phrase := Tokenize(strings.Trim(part, `"`))
+			switch len(phrase) {
+			case 0:
+			case 1:
+				pq.terms = append(pq.terms, phrase[0].Term)
+			default:
+				pq.phrases = append(pq.phrases, phrase)
+			}
+			continue
+		}
+		if key, value, ok := strings.Cut(part, ":"); ok && key != "" && value != "" {
+			key = strings.ToLower(key)
+			switch key {
+			case "tag":
+				pq.tags = append(pq.tags, strings.TrimPrefix(value, "#"))
+			case "path":
+				pq.paths = append(pq.paths, value)
+			default:
+				pq.fields[key] = append(pq.fields[key], value)
+			}
+			continue
+		}
+		for _, t := range Tokenize(part) {
+			pq.terms = append(pq.terms, t.Term)
+		}
+	}
+	return pq
+}
+
+// splitQuery splits q on whitespace, keeping "quoted phrases" (which may
+// follow a field prefix, as in title:"two words") together.
+func splitQuery(q string) []string {
+	var parts []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if cur.Len() > 0 {
+				parts = append(parts, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		parts = append(parts, cur.String())
+	}
+	for i, p := range parts {
+		if k, v, ok := strings.Cut(p, ":"); ok && !strings.HasPrefix(p, `"`) {
+			parts[i] = k + ":" + strings.Trim(v, `"`)
+		}
+	}
+	return parts
+}
+
+func (q query) empty() bool {
+	return len(q.terms) == 0 && len(q.phrases) == 0 && len(q.tags) == 0 &&
+		len(q.paths) == 0 && len(q.fields) == 0
+}
+
+// Search returns up to limit notes matching q, best first.
+func (ix *Index) Search(q string, limit int) []Result {
+	pq := parseQuery(q)
+	if pq.empty() {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	type hit struct {
+		path    string
+		score   float64
+		matches []int // indexes into doc.tokens
+	}
+	var hits []hit
+	avgLen := float64(ix.totalLen) / math.Max(1, float64(len(ix.docs)))
+
+	for p, doc := range ix.docs {
+		if !pq.matchesFilters(p, doc) {
+			continue
+		}
+		h := hit{path: p}
+		ok := true
+		for _, term := range pq.terms {
+			idx := ix.postings[term][p]
+			if len(idx) == 0 {
+				ok = false
+				break
+			}
+			h.score += ix.bm25(doc, idx, len(ix.postings[term]), avgLen)
+			h.matches = append(h.matches, idx...)
+		}
+		for _, phrase := range pq.phrases {
+			if !ok {
+				break
+			}
+			idx := ix.phraseMatches(p, doc, phrase)
+			if len(idx) == 0 {
+				ok = false
+				break
+			}
+			h.score += 2 * ix.bm25(doc, idx, ix.docFreq(phrase[0].Term), avgLen)
+			h.matches = append(h.matches, idx...)
+		}
+		if ok {
+			hits = append(hits, h)
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].path < hits[j].path
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	results := make([]Result, 0, len(hits))
+	for _, h := range hits {
+		doc := ix.docs[h.path]
+		results = append(results, Result{
+			Path:     h.path,
+			Title:    doc.title,
+			Score:    math.Round(h.score*1000) / 1000,
+			Snippets: snippets(doc, h.matches, 3),
+		})
+	}
+	return results
+}
+
+func (q query) matchesFilters(p string, doc *document) bool {
+	for _, want := range q.paths {
+		if !matchPath(p, want) {
+			return false
+		}
+	}
+	for _, want := range q.tags {
+		if !hasTag(doc.tags, want) {
+			return false
+		}
+	}
+	for key, wants := range q.fields {
+		for _, want := range wants {
+			if !hasValue(doc.fields[key], want) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchPath matches a glob ("daily/*.md") against the whole path, or a
+// plain string as a path prefix ("projects/").
+func matchPath(p, want string) bool {
+	if strings.ContainsAny(want, "*?[") {
+		ok, _ := path.Match(want, p)
+		return ok
+	}
+	return strings.HasPrefix(p, want)
+}
+
+// hasTag matches tags case-insensitively; "area" also matches nested tags
+// like "area/topic".
+func hasTag(tags []string, want string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, want) || len(t) > len(want) && strings.EqualFold(t[:len(want)+1], want+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasValue(values []string, want string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ix *Index) docFreq(term string) int {
+	return len(ix.postings[term])
+}
+
+// bm25 scores the matched tokens of a single query term in doc. Matches in
+// the title count three times.
+func (ix *Index) bm25(doc *document, matches []int, df int, avgLen float64) float64 {
+	const k1, b = 1.2, 0.75
+	tf := 0.0
+	for _, i := range matches {
+		if doc.tokens[i].Start < len(doc.title) {
+			tf += 3
+		} else {
+			tf++
+		}
+	}
+	n := float64(len(ix.docs))
+	idf := math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+	norm := k1 * (1 - b + b*float64(len(doc.tokens))/math.Max(1, avgLen))
+	return idf * tf * (k1 + 1) / (tf + norm)
+}
+
+// phraseMatches returns the token indexes of every word of every occurrence
+// of phrase in doc.
+func (ix *Index) phraseMatches(p string, doc *document, phrase []Token) []int {
+	byPos := make(map[int]int) // word position -> token index, for phrase terms
+	for _, t := range phrase {
+		for _, i := range ix.postings[t.Term][p] {
+			byPos[doc.tokens[i].Pos] = i
+		}
+	}
+
+	var out []int
+	for _, first := range ix.postings[phrase[0].Term][p] {
+		start := doc.tokens[first].Pos
+		match := []int{first}
+		for _, t := range phrase[1:] {
+			i, ok := byPos[start+t.Pos-phrase[0].Pos]
+			if !ok || doc.tokens[i].Term != t.Term {
+				match = nil
+				break
+			}
+			match = append(match, i)
+		}
+		out = append(out, match...)
+	}
+	return out
+}
+
+// snippets returns up to limit HTML excerpts of doc's body around matches,
+// highlighting every matched word.
+func snippets(doc *document, matches []int, limit int) []string {
+	const before, after = 60, 100
+
+	bodyStart := len(doc.title) + 2
+	sort.Ints(matches)
+	marked := make(map[int]bool, len(matches))
+	for _, i := range matches {
+		marked[i] = true
+	}
+
+	var out []string
+	end := -1
+	for _, i := range matches {
+		t := doc.tokens[i]
+		if t.Start < bodyStart || t.Start < end {
+			continue
+		}
+		start := runeStartAfter(doc.text, t.Start-before, bodyStart)
+		end = runeEndBefore(doc.text, t.End+after)
+
+		var sb strings.Builder
+		if start > bodyStart {
+			sb.WriteString("…")
+		}
+		pos := start
+		for j := i; j < len(doc.tokens) && doc.tokens[j].Start < end; j++ {
+			if !marked[j] || doc.tokens[j].Start < pos {
+				continue
+			}
+			sb.WriteString(html.EscapeString(doc.text[pos:doc.tokens[j].Start]))
+			sb.WriteString("<mark>" + html.EscapeString(doc.text[doc.tokens[j].Start:doc.tokens[j].End]) + "</mark>")
+			pos = doc.tokens[j].End
+		}
+		if pos < end {
+			sb.WriteString(html.EscapeString(doc.text[pos:end]))
+		}
+		if end < len(doc.text) {
+			sb.WriteString("…")
+		}
+		out = append(out, strings.Join(strings.Fields(sb.String()), " "))
+		if len(out) == limit {
+			break
+		}
+	}
+	if len(out) == 0 && len(doc.text) > bodyStart {
+		// Title or filter-only match: show the start of the note.
+		end := runeEndBefore(doc.text, bodyStart+before+after)
+		s := html.EscapeString(doc.text[bodyStart:end])
+		if end < len(doc.text) {
+			s += "…"
+		}
+		out = append(out, strings.Join(strings.Fields(s), " "))
+	}
+	return out
+}
+
+// runeStartAfter moves off back to a rune boundary, but no earlier than lo.
+func runeStartAfter(s string, off, lo int) int {
+	if off < lo {
+		return lo
+	}
+	for off > lo && !utf8.RuneStart(s[off]) {
+		off--
+	}
+	return off
+}
+
+// runeEndBefore clamps off to len(s) and moves it forward to a rune boundary.
+func runeEndBefore(s string, off int) int {
+	if off >= len(s) {
+		return len(s)
+	}
+	for off < len(s) && !utf8.RuneStart(s[off]) {
+		off++
+	}
+	return off
+}
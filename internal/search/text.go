@@ -0,0 +1,177 @@
+// Package search implements the text analysis shared by the published
+// site's static search index and the server's vault search.
+//
+// The static index is queried by JavaScript in the default templates, which
+// re-implements Tokenize and Stem; keep templates/search.html in sync with
+// any change here.
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Token is a single indexed word.
+type Token struct {
+	Term  string // lowercased and stemmed
+	Pos   int    // word position in the text, counting stop words
+	Start int    // byte offset of the word in the text
+	End   int
+}
+
+// Tokenize splits text into words (runs of letters and digits), lowercases
+// and stems them, and drops stop words. Positions still count stop words,
+// so phrase matching stays exact.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	pos := 0
+	start := -1
+	flush := func(end int) {
+		word := strings.ToLower(text[start:end])
+		if !IsStopWord(word) {
+			tokens = append(tokens, Token{Term: Stem(word), Pos: pos, Start: start, End: end})
+		}
+		pos++
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			flush(i)
+		}
+	}
+	if start >= 0 {
+		flush(len(text))
+	}
+	return tokens
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// IsStopWord reports whether a lowercased word is too common to index.
+func IsStopWord(word string) bool {
+	return stopWords[word]
+}
+
+// Stem reduces an English word to its stem using step 1 of the Porter
+// algorithm, which folds plurals and -ed/-ing forms ("ponies" → "poni",
+// "hoping" → "hope", "running" → "run"). Words that aren't plain lowercase
+// ASCII are returned unchanged.
+func Stem(w string) string {
+	if len(w) <= 2 {
+		return w
+	}
+	for i := 0; i < len(w); i++ {
+		if w[i] < 'a' || w[i] > 'z' {
+			return w
+		}
+	}
+
+	// Step 1a: plurals
+	switch {
+	case strings.HasSuffix(w, "sses"), strings.HasSuffix(w, "ies"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"):
+	case strings.HasSuffix(w, "s"):
+		w = w[:len(w)-1]
+	}
+
+	// Step 1b: -eed, -ed, -ing
+	if strings.HasSuffix(w, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			w = w[:len(w)-1]
+		}
+	} else if stem, ok := trimSuffixAfterVowel(w, "ed", "ing"); ok {
+		w = stem
+		switch {
+		case strings.HasSuffix(w, "at"), strings.HasSuffix(w, "bl"), strings.HasSuffix(w, "iz"):
+			w += "e"
+		case endsDoubleConsonant(w) && !strings.ContainsRune("lsz", rune(w[len(w)-1])):
+			w = w[:len(w)-1]
+		case measure(w) == 1 && endsCVC(w):
+			w += "e"
+		}
+	}
+
+	// Step 1c: y → i
+	if strings.HasSuffix(w, "y") && hasVowel(w[:len(w)-1]) {
+		w = w[:len(w)-1] + "i"
+	}
+	return w
+}
+
+func trimSuffixAfterVowel(w string, suffixes ...string) (string, bool) {
+	for _, s := range suffixes {
+		if strings.HasSuffix(w, s) && hasVowel(w[:len(w)-len(s)]) {
+			return w[:len(w)-len(s)], true
+		}
+	}
+	return w, false
+}
+
+func isConsonant(w string, i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(w, i-1)
+	}
+	return true
+}
+
+// measure counts the vowel-consonant sequences in w (Porter's m).
+func measure(w string) int {
+	m, i, n := 0, 0, len(w)
+	for i < n && isConsonant(w, i) {
+		i++
+	}
+	for i < n {
+		for i < n && !isConsonant(w, i) {
+			i++
+		}
+		if i >= n {
+			break
+		}
+		for i < n && isConsonant(w, i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+func hasVowel(w string) bool {
+	for i := range w {
+		if !isConsonant(w, i) {
+			return true
+		}
+	}
+	return false
+}
+
+func endsDoubleConsonant(w string) bool {
+	n := len(w)
+	return n >= 2 && w[n-1] == w[n-2] && isConsonant(w, n-1)
+}
+
+// endsCVC reports whether w ends consonant-vowel-consonant, where the final
+// consonant isn't w, x or y ("hop", but not "snow").
+func endsCVC(w string) bool {
+	n := len(w)
+	if n < 3 || !isConsonant(w, n-3) || isConsonant(w, n-2) || !isConsonant(w, n-1) {
+		return false
+	}
+	return !strings.ContainsRune("wxy", rune(w[n-1]))
+}
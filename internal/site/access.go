@@ -0,0 +1,225 @@
+package site
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Access says who may read a protected file: anyone who knows one of the
+// passwords, or any user in one of the groups.
+type Access struct {
+	Passwords []string
+	Groups    []string
+}
+
+// noteAccess returns who may read n's page.
+func noteAccess(n Note) Access {
+	var a Access
+	if n.Password != "" {
+		a.Passwords = []string{n.Password}
+	}
+	a.Groups = append(a.Groups, n.Access...)
+	return a
+}
+
+// merge adds the readers of o to a.
+func (a *Access) merge(o Access) {
+	for _, p := range o.Passwords {
+		if !slices.Contains(a.Passwords, p) {
+			a.Passwords = append(a.Passwords, p)
+		}
+	}
+	for _, g := range o.Groups {
+		if !slices.Contains(a.Groups, g) {
+			a.Groups = append(a.Groups, g)
+		}
+	}
+}
+
+// sameAccess reports whether a and b are readable by the same people, so
+// that one can show the other's content.
+func sameAccess(a, b Note) bool {
+	x, y := noteAccess(a), noteAccess(b)
+	return slices.Equal(x.Passwords, y.Passwords) && slices.Equal(x.Groups, y.Groups)
+}
+
+// accessSuffix names the file next to a build directory that lists who may
+// read the build's protected files. It is kept outside the build so it is
+// never served.
+const accessSuffix = ".access.json"
+
+// Access returns who may read the file at the URL path p as of the live
+// build, or false if anyone may. If no build's protection could be read,
+// nobody may read anything until a build succeeds.
+func (b *Builder) Access(p string) (Access, bool) {
+	b.accessMu.Lock()
+	defer b.accessMu.Unlock()
+	if b.access == nil {
+		return Access{}, true
+	}
+	a, ok := b.access[path.Clean("/"+p)]
+	return a, ok
+}
+
+func (b *Builder) setAccess(access map[string]Access) {
+	b.accessMu.Lock()
+	b.access = access
+	b.accessMu.Unlock()
+}
+
+// widenAccess adds the protection of next, the build about to go live, to
+// the live build's, since either may be served while they are swapped. It
+// returns the live build's protection.
+func (b *Builder) widenAccess(next map[string]Access) map[string]Access {
+	b.accessMu.Lock()
+	defer b.accessMu.Unlock()
+	old := b.access
+	if old != nil {
+		b.access = unionAccess(old, next)
+	}
+	return old
+}
+
+// unionAccess protects every file x or y protects, readable by the readers
+// of either.
+func unionAccess(x, y map[string]Access) map[string]Access {
+	u := make(map[string]Access, len(x)+len(y))
+	for _, m := range []map[string]Access{x, y} {
+		for p, a := range m {
+			merged := u[p]
+			merged.merge(a)
+			u[p] = merged
+		}
+	}
+	return u
+}
+
+// loadAccess reads the protection of the live build, so a restarted server
+// protects the build it serves before its first build succeeds. A build
+// without the file is from before protection and protects nothing. If the
+// file can't be read, the last known protection, that of an earlier build,
+// is used until the next build. It returns nil if there is none.
+func (b *Builder) loadAccess() map[string]Access {
+	live := b.liveBuildDir()
+	if live == "" {
+		return nil
+	}
+	access, err := readAccess(live + accessSuffix)
+	if err == nil {
+		return access
+	}
+	if os.IsNotExist(err) {
+		return make(map[string]Access)
+	}
+	log.Printf("load access: %v", err)
+
+	files, _ := filepath.Glob(filepath.Join(b.outDir, buildsDir, "*"+accessSuffix))
+	sort.Sort(sort.Reverse(sort.StringSlice(files))) // newest first
+	for _, f := range files {
+		if f == live+accessSuffix {
+			continue
+		}
+		if access, err := readAccess(f); err == nil {
+			log.Printf("load access: protecting what %s protected until the next build", filepath.Base(strings.TrimSuffix(f, accessSuffix)))
+			return access
+		}
+	}
+	log.Printf("load access: no protection known, denying every request until the next build")
+	return nil
+}
+
+func readAccess(file string) (map[string]Access, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var access map[string]Access
+	if err := json.Unmarshal(data, &access); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if access == nil {
+		access = make(map[string]Access)
+	}
+	return access, nil
+}
+
+// saveAccess writes the protection of the build being generated next to the
+// build directory dir.
+func (b *Builder) saveAccess(dir string) error {
+	access := b.nextAccess
+	if access == nil {
+		access = make(map[string]Access)
+	}
+	data, err := json.Marshal(access)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dir+accessSuffix, data)
+}
+
+// protectPage records the files of the page at rel, written from n, as
+// readable only by n's readers.
+func (bc *buildContext) protectPage(rel string, n Note) {
+	if !n.Protected() {
+		return
+	}
+	dir := path.Dir("/" + filepath.ToSlash(rel))
+	for _, p := range []string{dir, path.Join(dir, "index.html"), path.Join(dir, "card.png")} {
+		bc.protect(p, noteAccess(n))
+	}
+}
+
+func (bc *buildContext) protect(p string, a Access) {
+	merged := bc.access[p]
+	merged.merge(a)
+	bc.access[p] = merged
+}
+
+// protectImages records the images that only protected notes embed, and
+// their variants, as readable only by those notes' readers. An image any
+// unprotected note embeds stays public.
+func (b *Builder) protectImages(notes []Note, bc *buildContext) {
+	public := make(map[string]bool)
+	readers := make(map[string]Access)
+	for _, n := range notes {
+		for _, ref := range n.parsed.images {
+			rel, ok := b.resolveImage(n, ref)
+			if !ok {
+				continue
+			}
+			if !n.Protected() {
+				public[rel] = true
+				continue
+			}
+			a := readers[rel]
+			a.merge(noteAccess(n))
+			readers[rel] = a
+		}
+	}
+	for rel, a := range readers {
+		if public[rel] {
+			continue
+		}
+		for _, v := range b.imageVariants(rel, b.images[rel]) {
+			bc.protect(path.Join("/", bc.imageDir(rel), v.path), a)
+		}
+	}
+}
+
+// publicNotes returns the notes in notes that aren't protected.
+func publicNotes(notes []Note) []Note {
+	var public []Note
+	for _, n := range notes {
+		if !n.Protected() {
+			public = append(public, n)
+		}
+	}
+	return public
+}
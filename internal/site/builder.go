@@ -1,23 +1,25 @@
 package site
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"image"
 	"io"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/nilszeilon/notesync/internal/fileutil"
 	"github.com/nilszeilon/notesync/internal/markdown"
 	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/renderer/html"
 )
 
 type Note struct {
@@ -26,66 +28,254 @@ type Note struct {
 	Body     string // markdown body without frontmatter
 	FilePath string // relative path in storage
 	ModTime  time.Time
+	Hash     string // sha256 of the source file
+
+	date   time.Time // see parsedDate
+	parsed *noteParse
 }
 
 type Builder struct {
 	mu      sync.Mutex
 	dataDir string
 	outDir  string
-	md      goldmark.Markdown
+	config  Config
+	md      map[markdownFeatures]goldmark.Markdown
 	tmpl    *template.Template
 	css     []byte
+
+	// State kept between builds so unchanged pages aren't regenerated.
+	tmplKey     string
+	sources     map[string]cachedNote   // relPath -> parsed note
+	rendered    map[string]renderedNote // slug -> rendered body
+	plain       map[string]plainDoc     // slug -> searchable text
+	images      map[string]imageInfo    // vault path -> image
+	imageIndex  *markdown.ImageIndex
+	imageRefs   map[imageRef]string // resolved image references, "" if missing; reset with imageIndex
+	imageGen    int                 // counts the times imageIndex was built
+	links       *linkResolver       // the last build's, for the resolved links it remembers
+	attachments string              // attachment folder imageIndex was built with
+	nextChange  time.Time           // when a scheduled note next goes up or down
+	cardBG      image.Image         // social card background, nil for the default
+	cardKey     string
+	draftKey    []byte // key draft tokens are derived from
+	outputs     *outputSet
+	spare       string          // build directory before the live one, reused for the next build; "" if none
+	behind      map[string]bool // outputs that differ between the spare and the live build
+	scanned     bool            // the whole vault has been scanned into sources and images
+
+	changesMu sync.Mutex
+	changes   map[string]bool // vault paths changed since the last build; nil until Changed is called
+
+	accessMu   sync.Mutex
+	access     map[string]Access // URL path -> readers, for protected files; nil denies everything
+	nextAccess map[string]Access // access of the build being generated
+
+	moves      *moveState // published notes' slugs, to redirect the old URLs of moved notes
+	movesSaved []byte
+	redirectMu sync.Mutex
+	redirects  map[string]string // old URL path -> URL
+
+	checks map[string]noteCheck // storage path -> what the last report found in the note
+
+	reportMu   sync.Mutex
+	report     *Report
+	reportTmpl *template.Template // templates report was built with
+}
+
+// buildContext holds the note indexes computed once per build.
+type buildContext struct {
+	now        time.Time // the time scheduled notes are checked against
+	published  []Note    // sorted by date, newest first; excludes the home note
+	drafts     []Note    // unpublished notes shown at a preview URL
+	deps       *depGraph
+	slugIndex  map[string]Note // published notes and the home note
+	links      *linkResolver   // resolves wikilinks against all notes
+	tags       []tagGroup
+	groups     []noteGroup           // ordered for navigation; ungrouped notes first
+	groupIndex map[string]*noteGroup // slug -> group
+	images     map[string]bool       // vault paths of the images published notes and drafts embed
+	imageDirs  map[string]string     // vault path -> output folder, for images only drafts embed
+	access     map[string]Access     // URL path -> readers, for protected files
+	redirects  map[string]string     // old URL path -> URL
 }
 
-func NewBuilder(dataDir, outDir string) *Builder {
-	return &Builder{
-		dataDir: dataDir,
-		outDir:  outDir,
-		md: goldmark.New(
-			goldmark.WithExtensions(extension.GFM),
-			goldmark.WithRendererOptions(
-				html.WithUnsafe(),
-			),
-		),
+// cachedNote is a parsed note along with the file stamp it was parsed from.
+type cachedNote struct {
+	size    int64
+	modTime time.Time
+	note    Note
+}
+
+// renderedNote is a note's rendered HTML body and its headings, keyed on its
+// content inputs.
+type renderedNote struct {
+	key      string
+	html     template.HTML
+	headings []TOCEntry
+	feedHTML string // html as feeds show it, once a feed has
+}
+
+func NewBuilder(dataDir, outDir string, config Config) *Builder {
+	b := &Builder{
+		dataDir:  dataDir,
+		outDir:   outDir,
+		config:   config.withDefaults(),
+		md:       make(map[markdownFeatures]goldmark.Markdown),
+		sources:  make(map[string]cachedNote),
+		rendered: make(map[string]renderedNote),
+		plain:    make(map[string]plainDoc),
 	}
+	b.access = b.loadAccess()
+	return b
 }
 
+// Build regenerates the site into a fresh staging directory and, on success,
+// atomically swaps it in as the live site. Only outputs whose inputs changed
+// since the previous build are regenerated; the rest are carried over from the
+// live build. The first build, and any build after the templates changed,
+// regenerates everything. A failed build leaves the live site untouched.
 func (b *Builder) Build() error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	// Clean output directory contents (but not the dir itself, which may be a mount point)
-	entries, err := os.ReadDir(b.outDir)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("read output dir: %w", err)
+	// Load templates (user overrides from dataDir/templates/ if present)
+	tmplKey := userTemplatesKey(b.dataDir)
+	full := b.outputs == nil || tmplKey != b.tmplKey
+	if full {
+		if b.outputs == nil {
+			if err := b.cleanLegacyOutput(); err != nil {
+				return err
+			}
+		}
+		b.tmpl, b.css = loadUserTemplates(b.dataDir)
+		b.cardBG, b.cardKey = loadCardBackground(b.dataDir)
+	}
+
+	stage, reused, err := b.stage(full)
+	if err != nil {
+		return fmt.Errorf("stage build: %w", err)
 	}
-	for _, e := range entries {
-		os.RemoveAll(filepath.Join(b.outDir, e.Name()))
+
+	prev := b.outputs
+	if full {
+		b.outputs = newOutputSet(stage)
+	} else {
+		b.outputs = prev.clone(stage)
 	}
-	if err := os.MkdirAll(b.outDir, 0755); err != nil {
-		return fmt.Errorf("create output dir: %w", err)
+	b.outputs.begin()
+
+	changed := b.takeChanges()
+	if err := b.generate(changed); err != nil {
+		b.requeueChanges(changed)
+		b.unstage(stage, reused)
+		b.outputs = prev
+		if full {
+			b.tmplKey = "" // force a full rebuild next time
+		}
+		return err
 	}
 
-	// Load templates (user overrides from dataDir/templates/ if present)
-	b.tmpl, b.css = loadUserTemplates(b.dataDir)
+	if !full && len(b.outputs.changed) == 0 {
+		b.unstage(stage, reused)
+		b.outputs = prev
+		// Protection can change without changing any page.
+		if err := b.saveAccess(b.liveBuildDir()); err != nil {
+			return fmt.Errorf("save access: %w", err)
+		}
+		b.setAccess(b.nextAccess)
+		return nil
+	}
+
+	if err := b.saveAccess(stage); err != nil {
+		b.unstage(stage, reused)
+		b.outputs = prev
+		return fmt.Errorf("save access: %w", err)
+	}
+	live := b.liveBuildDir()
+	oldAccess := b.widenAccess(b.nextAccess)
+	if err := b.publish(stage); err != nil {
+		b.setAccess(oldAccess)
+		b.unstage(stage, reused)
+		b.outputs = prev
+		return fmt.Errorf("publish build: %w", err)
+	}
+	// The build that was live becomes the spare, behind by what this build
+	// changed. A full build may have changed any file, whatever its key, so
+	// it leaves no spare.
+	b.spare, b.behind = live, b.outputs.changed
+	if full {
+		b.spare, b.behind = "", nil
+	}
+	b.setAccess(b.nextAccess)
+	b.tmplKey = tmplKey
+	log.Printf("site build: %d outputs written or removed", len(b.outputs.changed))
+	return nil
+}
 
+// Changed tells the builder that the file at the vault path p was written
+// or removed. Once told about changes, the builder stops walking the vault on
+// every build and looks only at the files it was told about, so a build
+// after a single edit doesn't depend on the size of the vault.
+func (b *Builder) Changed(p string) {
+	b.changesMu.Lock()
+	defer b.changesMu.Unlock()
+	if b.changes == nil {
+		b.changes = make(map[string]bool)
+	}
+	b.changes[path.Clean(strings.TrimPrefix(filepath.ToSlash(p), "/"))] = true
+}
+
+// takeChanges returns the files changed since the last build, or nil if the
+// builder isn't told about changes.
+func (b *Builder) takeChanges() map[string]bool {
+	b.changesMu.Lock()
+	defer b.changesMu.Unlock()
+	changed := b.changes
+	if changed != nil {
+		b.changes = make(map[string]bool)
+	}
+	return changed
+}
+
+// requeueChanges hands the changes of a failed build to the next one.
+func (b *Builder) requeueChanges(changed map[string]bool) {
+	b.changesMu.Lock()
+	defer b.changesMu.Unlock()
+	for p := range changed {
+		b.changes[p] = true
+	}
+}
+
+// generate writes the site into b.outputs. changed lists the vault files
+// changed since the last build, or is nil if the whole vault must be
+// scanned.
+func (b *Builder) generate(changed map[string]bool) error {
 	// Collect all notes
-	notes, err := b.collectNotes()
+	notes, err := b.collectNotes(changed)
 	if err != nil {
 		return fmt.Errorf("collect notes: %w", err)
 	}
 
-	// Filter to published notes, separate out home note (landing page)
-	var published []Note
+	// Filter to published notes that are live now, separate out home note
+	// (landing page)
+	now := time.Now()
+	var published, drafts []Note
 	var homeNote *Note
+	b.nextChange = time.Time{}
 	for _, n := range notes {
 		if n.Slug == "home" {
 			n := n // copy
 			homeNote = &n
 			continue
 		}
-		if n.Publish {
+		if n.LiveAt(now) {
 			published = append(published, n)
+		} else if n.Draft() {
+			drafts = append(drafts, n)
+		}
+		if n.Published() {
+			b.scheduleChange(now, n.PublishAt.Time)
+			b.scheduleChange(now, n.UnpublishAt.Time)
 		}
 	}
 
@@ -96,220 +286,481 @@ func (b *Builder) Build() error {
 		return di.After(dj)
 	})
 
-	// Build backlink index (include home note if present)
+	// Build dependency graph (include home note if present)
 	allPublished := published
 	if homeNote != nil {
 		allPublished = append(allPublished, *homeNote)
 	}
-	backlinks := buildBacklinks(allPublished)
+	if len(drafts) > 0 {
+		if err := b.loadDraftKey(); err != nil {
+			return fmt.Errorf("draft key: %w", err)
+		}
+	}
+	bc := &buildContext{
+		now:       now,
+		published: published,
+		drafts:    drafts,
+		access:    make(map[string]Access),
+		slugIndex: make(map[string]Note),
+		tags:      collectTags(published),
+		groups:    collectGroups(published),
+	}
+
+	bc.groupIndex = make(map[string]*noteGroup)
+	for i := range bc.groups {
+		bc.groupIndex[bc.groups[i].Slug] = &bc.groups[i]
+	}
 
 	// Build slug->note index
-	slugIndex := make(map[string]Note)
 	for _, n := range allPublished {
-		slugIndex[n.Slug] = n
+		bc.slugIndex[n.Slug] = n
 	}
+	if b.moves == nil {
+		b.moves = b.loadMoves()
+	}
+	b.moves.track(allPublished, now)
+	bc.links = newLinkResolver(notes, bc.slugIndex, b.links)
+	b.links = bc.links
+	if err := b.scanImages(changed); err != nil {
+		return fmt.Errorf("scan images: %w", err)
+	}
+	b.scanned = true
+	// Drafts link to published notes like any other note, but aren't in
+	// slugIndex, so they never show up as backlinks or in embeds.
+	rendered := append(append([]Note(nil), drafts...), allPublished...)
+	bc.images = b.referencedImages(rendered)
+	bc.imageDirs = make(map[string]string)
+	public := b.referencedImages(allPublished)
+	for rel := range bc.images {
+		if !public[rel] {
+			bc.imageDirs[rel] = b.draftImageDir(rel)
+		}
+	}
+	bc.deps = newDepGraph(rendered, bc.links, func(n Note, ref string) string {
+		return b.imageStamp(n, ref, bc)
+	})
+	b.protectImages(rendered, bc)
 
-	// Generate note pages
-	for _, n := range published {
-		if err := b.buildNotePage(n, backlinks[n.Slug], slugIndex, b.outDir); err != nil {
-			return fmt.Errorf("build page %s: %w", n.Slug, err)
+	// Check links and images before writing anything
+	report := b.checkContent(notes, bc)
+	if err := b.saveReport(report); err != nil {
+		log.Printf("save build report: %v", err)
+	}
+	if n := report.Errors(); n > 0 {
+		if b.config.FailOnErrors {
+			return fmt.Errorf("build report has %d errors (see %s or /api/report.html)", n, filepath.Join(b.outDir, "report.html"))
+		}
+		log.Printf("site build: %d content errors, see %s or /api/report.html", n, filepath.Join(b.outDir, "report.html"))
+	}
+	keep := make(map[string]bool)
+	for _, n := range rendered {
+		keep[n.Slug] = true
+	}
+	for slug := range b.rendered {
+		if !keep[slug] {
+			delete(b.rendered, slug)
 		}
 	}
 
 	// Landing page: home.md renders at /, with the auto note listing at /index/.
 	// If there's no home.md, the note listing is the landing page at /.
 	if homeNote != nil {
-		if err := b.buildHomeFromNote(*homeNote, backlinks[homeNote.Slug], slugIndex); err != nil {
+		if err := b.buildHomeFromNote(*homeNote, bc); err != nil {
 			return fmt.Errorf("build home from note: %w", err)
 		}
-		if err := b.buildNoteIndex(published); err != nil {
+		if err := b.buildNoteIndex(bc); err != nil {
 			return fmt.Errorf("build note index: %w", err)
 		}
 	} else {
-		if err := b.buildIndex(published, b.outDir); err != nil {
+		if err := b.buildIndex(bc, ""); err != nil {
 			return fmt.Errorf("build index: %w", err)
 		}
 	}
 
+	// Tag overview and per-tag listings
+	if err := b.buildTagPages(bc); err != nil {
+		return fmt.Errorf("build tag pages: %w", err)
+	}
+
+	// Per-group listings
+	if err := b.buildGroupPages(bc); err != nil {
+		return fmt.Errorf("build group pages: %w", err)
+	}
+
 	// Copy style.css
 	if err := b.copyCSS(); err != nil {
 		return fmt.Errorf("write css: %w", err)
 	}
 
-	// Copy images
-	if err := b.copyImages(); err != nil {
-		return fmt.Errorf("copy images: %w", err)
+	// Publish the images published notes embed, and their resized variants
+	if err := b.publishImages(bc); err != nil {
+		return fmt.Errorf("publish images: %w", err)
 	}
 
-	// Generate search index JSON
-	if err := b.buildSearchIndex(published); err != nil {
+	// Generate search index JSON, leaving out protected notes
+	unprotected := publicNotes(published)
+	if err := b.buildSearchIndex(unprotected); err != nil {
 		return fmt.Errorf("build search index: %w", err)
 	}
+	if err := b.buildFullTextIndex(unprotected); err != nil {
+		return fmt.Errorf("build full-text index: %w", err)
+	}
+
+	// Generate RSS, Atom and JSON feeds
+	if err := b.buildFeeds(bc); err != nil {
+		return fmt.Errorf("build feeds: %w", err)
+	}
+
+	// Sitemap and robots.txt for search engines
+	if err := b.buildSitemap(bc); err != nil {
+		return fmt.Errorf("build sitemap: %w", err)
+	}
+	if err := b.buildRobots(); err != nil {
+		return fmt.Errorf("write robots.txt: %w", err)
+	}
+
+	// Generate note pages, after the site's own files so that a note's
+	// slug or permalink can't take the place of one of them
+	for _, n := range published {
+		if err := b.buildNotePage(n, bc); err != nil {
+			return fmt.Errorf("build page %s: %w", n.Slug, err)
+		}
+	}
+	for _, n := range drafts {
+		if err := b.buildDraftPage(n, bc); err != nil {
+			return fmt.Errorf("build draft %s: %w", n.Slug, err)
+		}
+	}
+
+	// Redirects from the old URLs of moved and renamed notes
+	if err := b.buildRedirects(bc); err != nil {
+		return fmt.Errorf("build redirects: %w", err)
+	}
+
+	// Drop pages and images that no longer exist
+	b.outputs.prune()
+
+	b.nextAccess = bc.access
+	b.redirectMu.Lock()
+	b.redirects = bc.redirects
+	b.redirectMu.Unlock()
+	if err := b.saveMoves(); err != nil {
+		log.Printf("save %s: %v", movesFile, err)
+	}
 
 	return nil
 }
 
-func (b *Builder) collectNotes() ([]Note, error) {
-	var notes []Note
-	err := filepath.Walk(b.dataDir, func(path string, info os.FileInfo, err error) error {
+// collectNotes returns the markdown files in dataDir as notes, in the order
+// a walk of dataDir visits them. The first build walks dataDir; later ones
+// look only at the files in changed, unless it is nil. Files whose size and
+// modtime match the previous build are taken from the cache instead of being
+// re-read.
+func (b *Builder) collectNotes(changed map[string]bool) ([]Note, error) {
+	if changed == nil || !b.scanned {
+		seen := make(map[string]bool)
+		err := filepath.Walk(b.dataDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != b.dataDir && filepath.Base(path) == "templates" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(strings.ToLower(path), ".md") {
+				return nil
+			}
+			relPath, _ := filepath.Rel(b.dataDir, path)
+			seen[relPath] = true
+			return b.loadNote(relPath, info)
+		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if info.IsDir() {
-			if path != b.dataDir && filepath.Base(path) == "templates" {
-				return filepath.SkipDir
+		for relPath := range b.sources {
+			if !seen[relPath] {
+				delete(b.sources, relPath)
 			}
-			return nil
 		}
-		if !strings.HasSuffix(strings.ToLower(path), ".md") {
-			return nil
+	} else {
+		for p := range changed {
+			relPath := filepath.FromSlash(p)
+			if !strings.HasSuffix(strings.ToLower(relPath), ".md") || inTemplates(p) {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(b.dataDir, relPath))
+			if os.IsNotExist(err) || err == nil && info.IsDir() {
+				delete(b.sources, relPath)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := b.loadNote(relPath, info); err != nil {
+				return nil, err
+			}
 		}
+	}
 
-		relPath, _ := filepath.Rel(b.dataDir, path)
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
+	notes := make([]Note, 0, len(b.sources))
+	for _, c := range b.sources {
+		notes = append(notes, c.note)
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		return walkOrder(notes[i].FilePath, notes[j].FilePath)
+	})
+	return notes, nil
+}
 
-		fm, body := markdown.ParseFrontmatter(string(data))
-		// Preserve folder structure in slug: "projects/foo.md" → "projects/foo"
-		slugBase := strings.TrimSuffix(relPath, filepath.Ext(relPath))
-		parts := strings.Split(filepath.ToSlash(slugBase), "/")
-		for i, p := range parts {
-			parts[i] = markdown.Slugify(p)
-		}
-		slug := strings.Join(parts, "/")
+// inTemplates reports whether the vault path p is in a templates folder,
+// which holds site templates rather than notes.
+func inTemplates(p string) bool {
+	parts := strings.Split(p, "/")
+	return slices.Contains(parts[:len(parts)-1], "templates")
+}
 
-		if fm.Title == "" {
-			fm.Title = strings.TrimSuffix(filepath.Base(relPath), filepath.Ext(relPath))
+// walkOrder reports whether filepath.Walk visits the relative path a before
+// b: folder by folder, in lexical order.
+func walkOrder(a, b string) bool {
+	x, y := strings.Split(filepath.ToSlash(a), "/"), strings.Split(filepath.ToSlash(b), "/")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			return x[i] < y[i]
 		}
+	}
+	return len(x) < len(y)
+}
 
-		notes = append(notes, Note{
-			Frontmatter: fm,
-			Slug:        slug,
-			Body:        body,
-			FilePath:    relPath,
-			ModTime:     info.ModTime(),
-		})
+// loadNote parses the markdown file at relPath into b.sources, unless its
+// size and modtime match the cached copy.
+func (b *Builder) loadNote(relPath string, info os.FileInfo) error {
+	if c, ok := b.sources[relPath]; ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
 		return nil
-	})
-	return notes, err
+	}
+	data, err := os.ReadFile(filepath.Join(b.dataDir, relPath))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+
+	fm, body := markdown.ParseFrontmatter(string(data))
+	// %% comments are private: drop them before anything reads the body
+	body = markdown.StripComments(body)
+	// Preserve folder structure in slug: "projects/foo.md" → "projects/foo",
+	// unless the note sets its own with slug or permalink
+	slugBase := strings.TrimSuffix(relPath, filepath.Ext(relPath))
+	parts := strings.Split(filepath.ToSlash(slugBase), "/")
+	for i, p := range parts {
+		parts[i] = markdown.Slugify(p)
+	}
+	slug := strings.Join(parts, "/")
+	if s := fm.PermalinkSlug(); s != "" {
+		slug = s
+	}
+
+	if fm.Title == "" {
+		fm.Title = strings.TrimSuffix(filepath.Base(relPath), filepath.Ext(relPath))
+	}
+	fm.Tags = markdown.MergeTags(fm.Tags, markdown.ExtractTags(body))
+	if !fm.PublishAt.Valid() || !fm.UnpublishAt.Valid() {
+		log.Printf("%s: can't parse publish_at or unpublish_at, keeping the note hidden", relPath)
+	}
+
+	n := Note{
+		Frontmatter: fm,
+		Slug:        slug,
+		Body:        body,
+		FilePath:    relPath,
+		ModTime:     info.ModTime(),
+		Hash:        hex.EncodeToString(sum[:]),
+	}
+	n.date = noteDate(n)
+	if c, ok := b.sources[relPath]; ok && c.note.Hash == n.Hash {
+		n.parsed = c.note.parsed
+	} else {
+		n.parsed = parseNote(n)
+	}
+	b.sources[relPath] = cachedNote{size: info.Size(), modTime: info.ModTime(), note: n}
+	return nil
 }
 
+// parsedDate is the date n is listed under: its date field, or else when it is
+// scheduled to go up, or else when it was last modified.
 func (n Note) parsedDate() time.Time {
+	return n.date
+}
+
+func noteDate(n Note) time.Time {
 	if n.Date != "" {
 		t, err := time.Parse("2006-01-02", n.Date)
 		if err == nil {
 			return t
 		}
 	}
+	if !n.PublishAt.IsZero() {
+		return n.PublishAt.Time
+	}
 	return n.ModTime
 }
 
-func (n Note) dateString() string {
-	d := n.parsedDate()
-	return d.Format("2006-01-02")
-}
-
-func buildBacklinks(notes []Note) map[string][]string {
-	// slug -> list of slugs that link to it
-	backlinks := make(map[string][]string)
-	for _, n := range notes {
-		links := markdown.ExtractWikiLinks(n.Body)
-		for _, target := range links {
-			backlinks[target] = append(backlinks[target], n.Slug)
-		}
+// scheduleChange records t as the next time the set of live notes changes if
+// it is in the future and sooner than any recorded so far.
+func (b *Builder) scheduleChange(now, t time.Time) {
+	if t.After(now) && (b.nextChange.IsZero() || t.Before(b.nextChange)) {
+		b.nextChange = t
 	}
-	return backlinks
 }
 
-func (b *Builder) buildNotePage(n Note, backlinkSlugs []string, slugIndex map[string]Note, notesDir string) error {
-	// Convert wikilinks in markdown before rendering
-	bodyWithLinks := ReplaceWikiLinks(n.Body)
+// NextChange returns when a scheduled note next goes up or comes down, as of
+// the last build, or the zero time if none is scheduled.
+func (b *Builder) NextChange() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextChange
+}
 
-	// Render markdown to HTML
-	var htmlBuf bytes.Buffer
-	if err := b.md.Convert([]byte(bodyWithLinks), &htmlBuf); err != nil {
-		return err
+// lastModified returns when n last changed: its modtime, or its date if
+// that's later.
+func (n Note) lastModified() time.Time {
+	if d := n.parsedDate(); d.After(n.ModTime) {
+		return d
 	}
+	return n.ModTime
+}
 
-	// Build backlink summaries
+func (n Note) dateString() string {
+	d := n.parsedDate()
+	return d.Format("2006-01-02")
+}
+
+func backlinkSummaries(n Note, bc *buildContext) []NoteSummary {
 	var backlinks []NoteSummary
 	seen := make(map[string]bool)
-	for _, slug := range backlinkSlugs {
+	for _, slug := range bc.deps.backlinks[n.Slug] {
 		if seen[slug] || slug == n.Slug {
 			continue
 		}
 		seen[slug] = true
-		if linked, ok := slugIndex[slug]; ok {
+		if linked, ok := bc.slugIndex[slug]; ok {
 			backlinks = append(backlinks, NoteSummary{
 				Title: linked.Title,
 				Slug:  linked.Slug,
 			})
 		}
 	}
+	return backlinks
+}
 
-	data := PageData{
-		Title:     n.Title,
-		DateStr:   n.dateString(),
-		Content:   template.HTML(htmlBuf.String()),
-		Backlinks: backlinks,
+func (b *Builder) buildNotePage(n Note, bc *buildContext) error {
+	rel := filepath.Join(n.Slug, "index.html")
+	if b.outputs.written[rel] || b.outputs.written[filepath.Join(n.Slug, "card.png")] {
+		log.Printf("not publishing %s at /%s/: the site has a file there", n.FilePath, n.Slug)
+		return nil
 	}
+	return b.writeNotePage(rel, n, bc)
+}
 
-	pageDir := filepath.Join(notesDir, n.Slug)
-	if err := os.MkdirAll(pageDir, 0755); err != nil {
-		return err
+func (b *Builder) buildHomeFromNote(n Note, bc *buildContext) error {
+	return b.writeNotePage("index.html", n, bc)
+}
+
+// writeNotePage renders n with page.html into rel, skipping the render when
+// none of the page's inputs changed since the previous build.
+func (b *Builder) writeNotePage(rel string, n Note, bc *buildContext) error {
+	backlinks := backlinkSummaries(n, bc)
+	var group *GroupSummary
+	groupKey := ""
+	if g := bc.group(n.Group); g != nil {
+		group, groupKey = &g.summary, g.key
 	}
-	outPath := filepath.Join(pageDir, "index.html")
-	f, err := os.Create(outPath)
-	if err != nil {
+	key := hashKey("page", n.Title, n.dateString(), n.Description, n.CoverImage(), bc.deps.contentKey(n), backlinks, groupKey)
+
+	bc.protectPage(rel, n)
+	if err := b.buildCard(rel, n, bc); err != nil {
 		return err
 	}
-	defer f.Close()
+	return b.outputs.emit(rel, key, func(w io.Writer) error {
+		r, err := b.renderNote(n, bc)
+		if err != nil {
+			return err
+		}
 
-	return b.tmpl.ExecuteTemplate(f, "page.html", data)
+		data := PageData{
+			Site:        b.siteData(),
+			Canonical:   b.config.canonicalURL(rel),
+			NoIndex:     n.NoIndex,
+			Description: b.description(n),
+			Image:       b.previewImage(rel, n, bc),
+			Slug:        n.Slug,
+			Title:       n.Title,
+			DateStr:     n.dateString(),
+			Content:     r.html,
+			Tags:        tagSummaries(n.Tags),
+			Group:       group,
+			Backlinks:   backlinks,
+			TOC:         pageTOC(n, r.headings),
+		}
+		return b.tmpl.ExecuteTemplate(w, "page.html", data)
+	})
 }
 
-func (b *Builder) buildHomeFromNote(n Note, backlinkSlugs []string, slugIndex map[string]Note) error {
-	bodyWithLinks := ReplaceWikiLinks(n.Body)
+// renderNote converts n's body to HTML, reusing the previous build's output
+// when none of its content inputs changed.
+func (b *Builder) renderNote(n Note, bc *buildContext) (renderedNote, error) {
+	key := bc.deps.contentKey(n)
+	if r, ok := b.rendered[n.Slug]; ok && r.key == key {
+		return r, nil
+	}
 
-	var htmlBuf bytes.Buffer
-	if err := b.md.Convert([]byte(bodyWithLinks), &htmlBuf); err != nil {
-		return err
+	// Convert wikilinks and embeds, then render markdown to HTML
+	out, headings, err := b.renderMarkdown(n, n.Body, bc, []string{n.Slug})
+	if err != nil {
+		return renderedNote{}, err
 	}
 
-	var backlinks []NoteSummary
-	seen := make(map[string]bool)
-	for _, slug := range backlinkSlugs {
-		if seen[slug] || slug == n.Slug {
-			continue
-		}
-		seen[slug] = true
-		if linked, ok := slugIndex[slug]; ok {
-			backlinks = append(backlinks, NoteSummary{
-				Title: linked.Title,
-				Slug:  linked.Slug,
-			})
+	r := renderedNote{key: key, html: template.HTML(out), headings: headings}
+	b.rendered[n.Slug] = r
+	return r, nil
+}
+
+// buildIndex writes the note listing to index.html under relDir, grouped by
+// the notes' group when any note has one.
+func (b *Builder) buildIndex(bc *buildContext, relDir string) error {
+	var groups []GroupSummary
+	if len(bc.groups) > 1 || len(bc.groups) == 1 && bc.groups[0].Name != "" {
+		for _, g := range bc.groups {
+			groups = append(groups, g.summary)
 		}
 	}
+	return b.buildListing(bc.published, relDir, "", groups)
+}
 
-	data := PageData{
-		Title:     n.Title,
-		DateStr:   n.dateString(),
-		Content:   template.HTML(htmlBuf.String()),
-		Backlinks: backlinks,
+// buildListing writes a listing of notes titled title to index.html under
+// relDir. An empty title renders the template's default heading.
+func (b *Builder) buildListing(notes []Note, relDir, title string, groups []GroupSummary) error {
+	rel := filepath.Join(relDir, "index.html")
+	data := IndexData{
+		Site:      b.siteData(),
+		Canonical: b.config.canonicalURL(rel),
+		Title:     title,
+		Notes:     noteSummaries(notes),
+		Groups:    groups,
 	}
 
-	f, err := os.Create(filepath.Join(b.outDir, "index.html"))
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	return b.outputs.emit(rel, hashKey("index", data), func(w io.Writer) error {
+		return b.tmpl.ExecuteTemplate(w, "index.html", data)
+	})
+}
 
-	return b.tmpl.ExecuteTemplate(f, "page.html", data)
+// buildNoteIndex writes the auto-generated note listing to /index/index.html,
+// so it's reachable at /index when home.md takes over the root.
+func (b *Builder) buildNoteIndex(bc *buildContext) error {
+	return b.buildIndex(bc, "index")
 }
 
-func (b *Builder) buildIndex(notes []Note, destDir string) error {
+func noteSummaries(notes []Note) []NoteSummary {
 	var summaries []NoteSummary
 	for _, n := range notes {
 		summaries = append(summaries, NoteSummary{
@@ -318,32 +769,15 @@ func (b *Builder) buildIndex(notes []Note, destDir string) error {
 			DateStr: n.dateString(),
 		})
 	}
-
-	data := IndexData{Notes: summaries}
-
-	if err := os.MkdirAll(destDir, 0755); err != nil {
-		return err
-	}
-	f, err := os.Create(filepath.Join(destDir, "index.html"))
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	return b.tmpl.ExecuteTemplate(f, "index.html", data)
-}
-
-// buildNoteIndex writes the auto-generated note listing to /index/index.html,
-// so it's reachable at /index when home.md takes over the root.
-func (b *Builder) buildNoteIndex(notes []Note) error {
-	return b.buildIndex(notes, filepath.Join(b.outDir, "index"))
+	return summaries
 }
 
 type searchEntry struct {
-	Title string `json:"title"`
-	Slug  string `json:"slug"`
-	Date  string `json:"date"`
-	Group string `json:"group,omitempty"`
+	Title string   `json:"title"`
+	Slug  string   `json:"slug"`
+	Date  string   `json:"date"`
+	Group string   `json:"group,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
 }
 
 func (b *Builder) buildSearchIndex(notes []Note) error {
@@ -354,51 +788,22 @@ func (b *Builder) buildSearchIndex(notes []Note) error {
 			Slug:  n.Slug,
 			Date:  n.dateString(),
 			Group: n.Group,
+			Tags:  n.Tags,
 		})
 	}
 	data, err := json.Marshal(entries)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(b.outDir, "search.json"), data, 0644)
+	return b.outputs.emit("search.json", hashKey(data), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
 }
 
 func (b *Builder) copyCSS() error {
-	return os.WriteFile(filepath.Join(b.outDir, "style.css"), b.css, 0644)
-}
-
-func (b *Builder) copyImages() error {
-	return filepath.Walk(b.dataDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		ext := strings.ToLower(filepath.Ext(path))
-		if !fileutil.ImageExts[ext] {
-			return nil
-		}
-
-		relPath, _ := filepath.Rel(b.dataDir, path)
-		destPath := filepath.Join(b.outDir, "images", relPath)
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-			return err
-		}
-
-		src, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer src.Close()
-
-		dst, err := os.Create(destPath)
-		if err != nil {
-			return err
-		}
-		defer dst.Close()
-
-		_, err = io.Copy(dst, src)
+	return b.outputs.emit("style.css", hashKey(b.css), func(w io.Writer) error {
+		_, err := w.Write(b.css)
 		return err
 	})
 }
@@ -0,0 +1,97 @@
+package site
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Config holds site-wide settings for the generated blog.
+type Config struct {
+	// BaseURL is the public URL the site is served from, e.g.
+	// "https://notes.example.com". Feeds need it for absolute links and are
+	// skipped when it is empty.
+	BaseURL string
+	Title   string
+	Author  string
+
+	// Language is the language of the notes as a BCP 47 tag, set on every
+	// page's <html lang>. Defaults to "en".
+	Language string
+
+	// Robots is the contents of /robots.txt. If empty, all crawlers are
+	// allowed and pointed at the sitemap.
+	Robots string
+
+	// FailOnErrors fails builds whose report has errors (broken links,
+	// missing images, duplicate slugs), keeping the previous build live.
+	FailOnErrors bool
+
+	// DisableMath leaves $...$ and $$...$$ as plain text instead of
+	// rendering them as MathML. Notes can override it with "math: true" or
+	// "math: false" in their frontmatter.
+	DisableMath bool
+
+	// The other optional markdown features: footnotes ([^1]), definition
+	// lists, smart quotes and dashes, and :emoji: shortcodes are on unless
+	// disabled; Sidenotes also shows footnotes in the page margin on wide
+	// screens. Notes can override each in their frontmatter.
+	DisableFootnotes       bool
+	Sidenotes              bool
+	DisableDefinitionLists bool
+	DisableTypographer     bool
+	DisableEmoji           bool
+
+	// ImageWidths are the widths, in pixels, of the resized copies published
+	// for each JPEG and PNG image and offered to browsers in a srcset. Nil
+	// means 480, 960 and 1600; an empty slice publishes images at full size
+	// only.
+	ImageWidths []int
+
+	// AttachmentFolder is Obsidian's attachment folder setting, used when
+	// resolving image embeds. If empty, it's read from .obsidian/app.json in
+	// the vault, if there is one.
+	AttachmentFolder string
+}
+
+func (c Config) withDefaults() Config {
+	c.BaseURL = strings.TrimRight(strings.TrimSpace(c.BaseURL), "/")
+	if c.BaseURL != "" && !strings.Contains(c.BaseURL, "://") {
+		c.BaseURL = "https://" + c.BaseURL
+	}
+	if c.Title == "" {
+		c.Title = "Notes"
+	}
+	c.Language = strings.TrimSpace(c.Language)
+	if c.Language == "" {
+		c.Language = "en"
+	}
+	return c
+}
+
+// absURL joins an absolute site path like "/foo/" onto the base URL.
+func (c Config) absURL(path string) string {
+	return c.BaseURL + path
+}
+
+// canonicalURL returns the public URL of the page written to rel, such as
+// "foo/index.html", or "" if the base URL isn't known.
+func (c Config) canonicalURL(rel string) string {
+	if c.BaseURL == "" {
+		return ""
+	}
+	return c.absURL(pagePath(rel))
+}
+
+// pagePath returns the site path a page written to rel is served at.
+func pagePath(rel string) string {
+	return "/" + strings.TrimSuffix(filepath.ToSlash(rel), "index.html")
+}
+
+func (b *Builder) siteData() SiteData {
+	return SiteData{
+		Title:    b.config.Title,
+		Author:   b.config.Author,
+		Language: b.config.Language,
+		BaseURL:  b.config.BaseURL,
+	}
+}
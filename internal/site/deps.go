@@ -0,0 +1,136 @@
+package site
+
+import (
+	"sort"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+)
+
+// depGraph records what each published page is rendered from besides its own
+// source: the notes it links to, the notes it embeds, the notes linking to
+// or embedding it (shown as backlinks) and the images it embeds. A page is
+// re-rendered only when the key derived from these inputs changes.
+type depGraph struct {
+	notes     map[string]Note           // slug -> note
+	links     map[string][]resolvedLink // slug -> its wikilinks and embeds
+	embeds    map[string][]string       // slug -> slugs of the notes it embeds
+	backlinks map[string][]string       // slug -> slugs linking to it
+	images    map[string][]string       // slug -> stamps of embedded images
+	keys      map[string]string         // slug -> hash of its content inputs, once worked out
+}
+
+// resolvedLink is a wikilink and the URL it resolved to, or "" if its
+// target is missing or unpublished.
+type resolvedLink struct {
+	Link  markdown.WikiLink
+	Href  string
+	Embed bool
+}
+
+// noteParse is what the builder reads out of a note's body besides its HTML:
+// its links, embeds and images, and the anchors other notes link to. It is
+// worked out once per version of the note rather than on every build. Links
+// and embeds in code are only text and are left out.
+type noteParse struct {
+	links   []markdown.WikiLink // wikilinks, once each
+	embeds  []markdown.WikiLink // note embeds, once each
+	refs    []string            // wikilinks and note embeds as written, for the report
+	images  []string            // see noteImageRefs
+	anchors noteAnchors
+}
+
+func parseNote(n Note) *noteParse {
+	masked := markdown.MaskCode(n.Body)
+	return &noteParse{
+		links:   markdown.ExtractWikiLinks(masked),
+		embeds:  markdown.ExtractEmbeds(masked),
+		refs:    wikiRefs(masked),
+		images:  noteImageRefs(n),
+		anchors: findAnchors(n.Body),
+	}
+}
+
+// newDepGraph records the dependencies of notes. imageStamp identifies the
+// image an embed refers to, and changes when the image does.
+func newDepGraph(notes []Note, resolver *linkResolver, imageStamp func(n Note, ref string) string) *depGraph {
+	g := &depGraph{
+		notes:     make(map[string]Note),
+		links:     make(map[string][]resolvedLink),
+		embeds:    make(map[string][]string),
+		backlinks: make(map[string][]string),
+		images:    make(map[string][]string),
+		keys:      make(map[string]string),
+	}
+	for _, n := range notes {
+		g.notes[n.Slug] = n
+		linked := make(map[string]bool)
+		links := n.parsed.links
+		for i, l := range append(links[:len(links):len(links)], n.parsed.embeds...) {
+			embed := i >= len(links)
+			href, _ := resolver.href(l, n)
+			g.links[n.Slug] = append(g.links[n.Slug], resolvedLink{Link: l, Href: href, Embed: embed})
+			target, ok := resolver.resolve(l.Target, n)
+			if !ok || href == "" {
+				continue
+			}
+			if embed {
+				g.embeds[n.Slug] = append(g.embeds[n.Slug], target.Slug)
+			}
+			if !linked[target.Slug] {
+				linked[target.Slug] = true
+				g.backlinks[target.Slug] = append(g.backlinks[target.Slug], n.Slug)
+			}
+		}
+		for _, ref := range n.parsed.images {
+			g.images[n.Slug] = append(g.images[n.Slug], imageStamp(n, ref))
+		}
+	}
+	for slug := range g.backlinks {
+		sort.Strings(g.backlinks[slug])
+	}
+	return g
+}
+
+// contentInputs is everything a note's rendered body depends on. A page's
+// output key combines it with the page chrome (title, date, backlinks).
+type contentInputs struct {
+	Source string
+	Title  string // shown above the note where it's embedded
+	Links  []resolvedLink
+	Images []string
+	Embeds []contentInputs
+}
+
+func (g *depGraph) contentInputs(n Note) contentInputs {
+	return g.inputs(n, map[string]bool{n.Slug: true})
+}
+
+// contentKey hashes n's content inputs. It changes whenever n's rendered
+// body would.
+func (g *depGraph) contentKey(n Note) string {
+	key, ok := g.keys[n.Slug]
+	if !ok {
+		key = hashKey(g.contentInputs(n))
+		g.keys[n.Slug] = key
+	}
+	return key
+}
+
+// inputs collects n's content inputs, including those of the notes it embeds
+// and the notes they embed in turn. seen guards against embed cycles.
+func (g *depGraph) inputs(n Note, seen map[string]bool) contentInputs {
+	in := contentInputs{
+		Source: n.Hash,
+		Title:  n.Title,
+		Links:  g.links[n.Slug],
+		Images: g.images[n.Slug],
+	}
+	for _, slug := range g.embeds[n.Slug] {
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		in.Embeds = append(in.Embeds, g.inputs(g.notes[slug], seen))
+	}
+	return in
+}
@@ -0,0 +1,90 @@
+package site
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nilszeilon/notesync/internal/fileutil"
+)
+
+// draftsDir is the folder draft previews are published under, each in a
+// folder named by its token.
+const draftsDir = "drafts"
+
+// draftKeyFile holds the key draft tokens are derived from. It lives in the
+// output directory, outside the builds, so tokens survive rebuilds and
+// restarts; deleting it changes every draft's URL.
+const draftKeyFile = ".draft-key"
+
+// draftTokenLength is the length of a draft token in hex digits.
+const draftTokenLength = 32
+
+// loadDraftKey reads the draft key, creating it on first use.
+func (b *Builder) loadDraftKey() error {
+	if b.draftKey != nil {
+		return nil
+	}
+	key, err := fileutil.LoadKey(filepath.Join(b.outDir, draftKeyFile))
+	if err != nil {
+		return err
+	}
+	b.draftKey = key
+	return nil
+}
+
+// draftToken returns the secret folder n's preview is published in. It
+// depends only on the note's path, so links to a draft keep working while
+// it is edited.
+func (b *Builder) draftToken(n Note) string {
+	mac := hmac.New(sha256.New, b.draftKey)
+	mac.Write([]byte(notePath(n)))
+	return hex.EncodeToString(mac.Sum(nil))[:draftTokenLength]
+}
+
+// draftImageDir returns the secret folder an image only drafts embed is
+// published in, instead of under images/ where anyone could find it.
+func (b *Builder) draftImageDir(rel string) string {
+	mac := hmac.New(sha256.New, b.draftKey)
+	mac.Write([]byte("image|" + rel))
+	return path.Join(draftsDir, hex.EncodeToString(mac.Sum(nil))[:draftTokenLength])
+}
+
+// draftPath returns the output path of n's preview page.
+func (b *Builder) draftPath(n Note) string {
+	return filepath.Join(draftsDir, b.draftToken(n), filepath.FromSlash(n.Slug), "index.html")
+}
+
+// draftURL returns the URL of n's preview page, absolute if the site knows
+// its base URL.
+func (b *Builder) draftURL(n Note) string {
+	u := pagePath(filepath.ToSlash(b.draftPath(n)))
+	if b.config.BaseURL != "" {
+		return b.config.absURL(u)
+	}
+	return u
+}
+
+// buildDraftPage writes n's preview page. Drafts stay out of the listings,
+// feeds, search and sitemap, and ask search engines not to index them.
+func (b *Builder) buildDraftPage(n Note, bc *buildContext) error {
+	n.NoIndex = true
+	return b.writeNotePage(b.draftPath(n), n, bc)
+}
+
+// IsDraftPath reports whether the URL path p is the drafts folder or inside
+// a draft preview.
+func IsDraftPath(p string) bool {
+	parts := strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/")
+	if parts[0] != draftsDir {
+		return false
+	}
+	if len(parts) == 1 {
+		return true
+	}
+	_, err := hex.DecodeString(parts[1])
+	return len(parts[1]) == draftTokenLength && err == nil
+}
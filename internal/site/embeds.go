@@ -0,0 +1,259 @@
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// maxEmbedDepth limits how deeply embedded notes may embed further notes.
+// Deeper embeds, and embeds that would include a note or section in itself,
+// are rendered as plain links.
+const maxEmbedDepth = 4
+
+// renderMarkdown renders body, which is n's body or a section of it, to
+// HTML. Notes embedded with ![[note]] are rendered separately and spliced
+// into the result, so their wikilinks resolve relative to their own note.
+// stack holds the embedKeys of the notes and sections being rendered,
+// outermost first. The headings returned are body's own, not those of
+// embedded notes.
+func (b *Builder) renderMarkdown(n Note, body string, bc *buildContext, stack []string) (string, []TOCEntry, error) {
+	var embeds []string
+	var embedErr error
+	src := ReplaceWikiLinks(body, func(l markdown.WikiLink) (string, bool) {
+		return bc.links.href(l, n)
+	}, func(ref string) string {
+		if rel, ok := b.resolveImage(n, ref); ok {
+			return bc.imageURL(rel, rel)
+		}
+		return "/images/" + ref
+	}, func(match string) string {
+		h, err := b.renderEmbed(n, markdown.ParseWikiLink(match[3:len(match)-2]), bc, stack)
+		if err != nil && embedErr == nil {
+			embedErr = err
+		}
+		embeds = append(embeds, h)
+		return embedPlaceholder(len(embeds) - 1)
+	})
+	if embedErr != nil {
+		return "", nil, embedErr
+	}
+
+	md := b.markdown(n)
+	source := []byte(src)
+	prefix := embedIDPrefix(stack)
+	ctx := parser.NewContext(parser.WithIDs(newHeadingIDs(prefix)))
+	doc := md.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+	if prefix != "" {
+		doc.OwnerDocument().AddMeta(footnotePrefixKey, prefix)
+	}
+	headings := collectHeadings(doc, source)
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
+		return "", nil, err
+	}
+
+	out := buf.String()
+	if f := b.features(n); f.footnotes && f.sidenotes {
+		out = sidenotes(out)
+	}
+	out = b.responsiveImages(n, out, bc)
+	for i, e := range embeds {
+		ph := embedPlaceholder(i)
+		at := strings.Index(out, ph)
+		if at < 0 {
+			continue
+		}
+		if strings.HasPrefix(e, "<div") && strings.HasSuffix(out[:at], "<p>") && strings.HasPrefix(out[at+len(ph):], "</p>") {
+			// An embed on a line of its own replaces the paragraph.
+			out = out[:at-len("<p>")] + e + out[at+len(ph)+len("</p>"):]
+		} else {
+			out = out[:at] + e + out[at+len(ph):]
+		}
+	}
+	return out, headings, nil
+}
+
+// embedPlaceholder stands in for an embed's HTML while the surrounding
+// markdown is rendered. It is plain letters and digits so markdown leaves it
+// alone.
+func embedPlaceholder(i int) string {
+	return fmt.Sprintf("NOTESYNCEMBED%dX", i)
+}
+
+// embedKey identifies what l embeds from the note with the given slug: the
+// slug alone for the whole note, or with the heading or block appended.
+func embedKey(slug string, l markdown.WikiLink) string {
+	switch {
+	case l.Heading != "":
+		return slug + "#" + markdown.HeadingID(l.Heading)
+	case l.Block != "":
+		return slug + "#^" + l.Block
+	}
+	return slug
+}
+
+var idPrefixReplacer = strings.NewReplacer("/", "-", "#", "-", "^", "")
+
+// embedIDPrefix is the prefix for heading and footnote ids in an embed, so
+// they don't clash with the page's or other embeds' ids. The page itself
+// gets none.
+func embedIDPrefix(stack []string) string {
+	if len(stack) < 2 {
+		return ""
+	}
+	return idPrefixReplacer.Replace(strings.Join(stack[1:], "--")) + "-"
+}
+
+// sectionEmbeds reports whether section, a heading section or block of n,
+// embeds key, as a section that embeds itself would otherwise be shown once
+// more inside itself.
+func (b *Builder) sectionEmbeds(n Note, section, key string, bc *buildContext) bool {
+	if !strings.Contains(key, "#") {
+		return false
+	}
+	for _, match := range markdown.ObsidianEmbedRe.FindAllString(section, -1) {
+		inner := match[3 : len(match)-2]
+		if !markdown.IsNoteEmbed(inner) {
+			continue
+		}
+		l := markdown.ParseWikiLink(inner)
+		target := n
+		if l.Target != "" {
+			target, _ = bc.links.resolve(l.Target, n)
+		}
+		if embedKey(target.Slug, l) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// embedsItself reports whether embedding key from within stack would render
+// the embed again: a whole note contains all of its sections, but a section
+// only contains itself if the embed is inside it.
+func embedsItself(stack []string, key string) bool {
+	slug, _, section := strings.Cut(key, "#")
+	for _, k := range stack {
+		s, _, _ := strings.Cut(k, "#")
+		if k == key || !section && s == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// renderEmbed renders the note, heading section or block l embeds from the
+// note from.
+func (b *Builder) renderEmbed(from Note, l markdown.WikiLink, bc *buildContext, stack []string) (string, error) {
+	href, ok := bc.links.href(l, from)
+	if !ok {
+		return wikiLinkHTML(l, "", false, ""), nil
+	}
+	target := from
+	if l.Target != "" {
+		target, _ = bc.links.resolve(l.Target, from)
+	}
+	// A protected note's content is only shown to its own readers.
+	key := embedKey(target.Slug, l)
+	if len(stack) > maxEmbedDepth || embedsItself(stack, key) || target.Protected() && !sameAccess(target, from) {
+		return wikiLinkHTML(l, href, true, "markdown-embed-link"), nil
+	}
+	section, ok := embedSection(target.Body, l)
+	if !ok {
+		return wikiLinkHTML(l, "", false, ""), nil
+	}
+	if b.sectionEmbeds(target, section, key, bc) {
+		return wikiLinkHTML(l, href, true, "markdown-embed-link"), nil
+	}
+
+	content, _, err := b.renderMarkdown(target, section, bc, append(stack[:len(stack):len(stack)], key))
+	if err != nil {
+		return "", fmt.Errorf("embed %s: %w", target.Slug, err)
+	}
+
+	title := target.Title
+	if l.Heading != "" {
+		title += " › " + l.Heading
+	}
+	return `<div class="markdown-embed">` +
+		`<div class="markdown-embed-title"><a href="` + html.EscapeString(href) + `">` + html.EscapeString(title) + `</a></div>` +
+		`<div class="markdown-embed-content">` + content + `</div>` +
+		`</div>`, nil
+}
+
+var listItemRe = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s`)
+
+// embedSection returns the part of body that l embeds: the whole body, the
+// named heading with everything up to the next heading of the same or a
+// higher level, or the block marked with l.Block. ok is false if the heading
+// or block doesn't exist.
+func embedSection(body string, l markdown.WikiLink) (string, bool) {
+	if l.Heading == "" && l.Block == "" {
+		return body, true
+	}
+
+	// Headings and block ids are looked for outside code, in masked.
+	lines := strings.Split(body, "\n")
+	masked := strings.Split(markdown.MaskCode(body), "\n")
+
+	if l.Heading != "" {
+		want := markdown.HeadingID(l.Heading)
+		start, level := -1, 0
+		for i, line := range lines {
+			m := atxHeadingRe.FindStringSubmatch(line)
+			if m == nil || !atxHeadingRe.MatchString(masked[i]) {
+				continue
+			}
+			lv := strings.Count(strings.Fields(line)[0], "#")
+			if start >= 0 && lv <= level {
+				return strings.Join(lines[start:i], "\n"), true
+			}
+			if start < 0 && markdown.HeadingID(wikiLinksToText(m[1])) == want {
+				start, level = i, lv
+			}
+		}
+		if start < 0 {
+			return "", false
+		}
+		return strings.Join(lines[start:], "\n"), true
+	}
+
+	for i, line := range lines {
+		m := markdown.BlockIDRe.FindStringSubmatch(masked[i])
+		if m == nil || m[2] != l.Block {
+			continue
+		}
+		end := i
+		if strings.HasPrefix(strings.TrimSpace(line), "^") {
+			// A block ID on its own line marks the block above it.
+			end = i - 1
+			for end >= 0 && strings.TrimSpace(lines[end]) == "" {
+				end--
+			}
+			if end < 0 {
+				return "", false
+			}
+		} else if listItemRe.MatchString(line) {
+			return line, true
+		}
+		start := end
+		for start > 0 && strings.TrimSpace(lines[start-1]) != "" && !atxHeadingRe.MatchString(lines[start-1]) {
+			start--
+		}
+		stop := end + 1
+		if end == i {
+			for stop < len(lines) && strings.TrimSpace(lines[stop]) != "" {
+				stop++
+			}
+		}
+		return strings.Join(lines[start:stop], "\n"), true
+	}
+	return "", false
+}
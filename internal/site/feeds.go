@@ -0,0 +1,382 @@
+package site
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"path"
+	"regexp"
+	"time"
+)
+
+// feedItemLimit caps the number of notes in each feed, newest first.
+const feedItemLimit = 20
+
+// feed is the format-independent description of a single feed, rendered as
+// RSS 2.0, Atom and JSON Feed side by side in dir.
+type feed struct {
+	dir     string // output dir relative to the site root, "" for the main feed
+	title   string
+	homeURL string
+	updated time.Time
+	items   []feedItem
+}
+
+type feedItem struct {
+	Title     string
+	URL       string
+	Published time.Time
+	Updated   time.Time
+	Tags      []string
+	Content   string // key of the note's rendered body, which stands in for HTML in the feed's key
+	HTML      string `json:"-"` // filled in only when the feed is written
+
+	note Note
+}
+
+var (
+	rootRelativeAttrRe = regexp.MustCompile(`(href|src)="/([^/"])`)
+	srcsetAttrRe       = regexp.MustCompile(`srcset="[^"]*"`)
+	srcsetURLRe        = regexp.MustCompile(`(srcset="|, )/([^/])`)
+)
+
+// headingAnchorRe matches the self-links on headings, which feeds leave out
+// since readers show them without the stylesheet that hides them.
+var headingAnchorRe = regexp.MustCompile(`<a class="heading-anchor" [^>]*>#</a>`)
+
+// absolutizeHTML rewrites root-relative links and image sources in rendered
+// note HTML to absolute URLs, since feed readers resolve them elsewhere.
+func (c Config) absolutizeHTML(html string) string {
+	html = rootRelativeAttrRe.ReplaceAllString(html, `$1="`+c.BaseURL+`/$2`)
+	return srcsetAttrRe.ReplaceAllStringFunc(html, func(attr string) string {
+		return srcsetURLRe.ReplaceAllString(attr, `${1}`+c.BaseURL+`/$2`)
+	})
+}
+
+// buildFeeds writes the site-wide feeds plus one set per group and per tag.
+func (b *Builder) buildFeeds(bc *buildContext) error {
+	if b.config.BaseURL == "" {
+		return nil
+	}
+
+	var notes []Note
+	for _, n := range bc.published {
+		if n.InFeed() && !n.Protected() {
+			notes = append(notes, n)
+		}
+	}
+
+	type feedSpec struct {
+		dir, title string
+		notes      []Note
+	}
+	specs := []feedSpec{{"", b.config.Title, notes}}
+	for _, g := range bc.groups {
+		if g.Slug == "" {
+			continue
+		}
+		var grouped []Note
+		for _, n := range bc.published {
+			if groupSlug(n.Group) == g.Slug && n.InFeed() && !n.Protected() {
+				grouped = append(grouped, n)
+			}
+		}
+		if len(grouped) > 0 {
+			specs = append(specs, feedSpec{path.Join("groups", g.Slug), b.config.Title + " — " + g.Name, grouped})
+		}
+	}
+	for _, g := range bc.tags {
+		var tagged []Note
+		for _, n := range g.Notes {
+			if n.InFeed() && !n.Protected() {
+				tagged = append(tagged, n)
+			}
+		}
+		if len(tagged) > 0 {
+			specs = append(specs, feedSpec{path.Join("tags", g.Slug), b.config.Title + " — #" + g.Name, tagged})
+		}
+	}
+
+	for _, spec := range specs {
+		homePath := "/"
+		if spec.dir != "" {
+			homePath = "/" + spec.dir + "/"
+		}
+		f := b.newFeed(spec.dir, spec.title, homePath, spec.notes, bc)
+		if err := b.writeFeed(f, bc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) newFeed(dir, title, homePath string, notes []Note, bc *buildContext) feed {
+	f := feed{dir: dir, title: title, homeURL: b.config.absURL(homePath)}
+	if len(notes) > feedItemLimit {
+		notes = notes[:feedItemLimit]
+	}
+	for _, n := range notes {
+		item := feedItem{
+			Title:     n.Title,
+			URL:       b.config.absURL("/" + n.Slug + "/"),
+			Published: n.parsedDate().UTC(),
+			Updated:   n.lastModified().UTC(),
+			Tags:      n.Tags,
+			Content:   bc.deps.contentKey(n),
+			note:      n,
+		}
+		if item.Updated.After(f.updated) {
+			f.updated = item.Updated
+		}
+		f.items = append(f.items, item)
+	}
+	return f
+}
+
+// feedHTML fills in the HTML of the items in f, reusing what other feeds
+// showing the same notes worked out.
+func (b *Builder) feedHTML(f feed, bc *buildContext) error {
+	for i, it := range f.items {
+		if it.HTML != "" {
+			continue
+		}
+		r, err := b.renderNote(it.note, bc)
+		if err != nil {
+			return err
+		}
+		if r.feedHTML == "" {
+			r.feedHTML = b.config.absolutizeHTML(headingAnchorRe.ReplaceAllString(string(r.html), ""))
+			b.rendered[it.note.Slug] = r
+		}
+		f.items[i].HTML = r.feedHTML
+	}
+	return nil
+}
+
+func (b *Builder) writeFeed(f feed, bc *buildContext) error {
+	key := hashKey("feed", f.title, f.homeURL, b.config.Author, f.items)
+	outputs := []struct {
+		name  string
+		write func(io.Writer, feed) error
+	}{
+		{"feed.xml", b.writeRSS},
+		{"atom.xml", b.writeAtom},
+		{"feed.json", b.writeJSONFeed},
+	}
+	for _, o := range outputs {
+		err := b.outputs.emit(path.Join(f.dir, o.name), key, func(w io.Writer) error {
+			if err := b.feedHTML(f, bc); err != nil {
+				return err
+			}
+			return o.write(w, f)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- RSS 2.0 ---
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (b *Builder) writeRSS(w io.Writer, f feed) error {
+	ch := rssChannel{
+		Title:       f.title,
+		Link:        f.homeURL,
+		Description: f.title,
+		Self: atomLink{
+			Href: b.config.absURL("/" + path.Join(f.dir, "feed.xml")),
+			Rel:  "self",
+			Type: "application/rss+xml",
+		},
+	}
+	if !f.updated.IsZero() {
+		ch.LastBuildDate = f.updated.Format(time.RFC1123Z)
+	}
+	for _, it := range f.items {
+		ch.Items = append(ch.Items, rssItem{
+			Title:       it.Title,
+			Link:        it.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: it.URL},
+			PubDate:     it.Published.Format(time.RFC1123Z),
+			Categories:  it.Tags,
+			Description: it.HTML,
+		})
+	}
+	return writeXML(w, rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: ch,
+	})
+}
+
+// --- Atom ---
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	NS      string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (b *Builder) writeAtom(w io.Writer, f feed) error {
+	author := b.config.Author
+	if author == "" {
+		author = b.config.Title
+	}
+	af := atomFeed{
+		NS:      "http://www.w3.org/2005/Atom",
+		Title:   f.title,
+		ID:      f.homeURL,
+		Updated: f.updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: b.config.absURL("/" + path.Join(f.dir, "atom.xml")), Rel: "self", Type: "application/atom+xml"},
+			{Href: f.homeURL, Rel: "alternate", Type: "text/html"},
+		},
+		Author: atomAuthor{Name: author},
+	}
+	for _, it := range f.items {
+		var categories []atomCategory
+		for _, t := range it.Tags {
+			categories = append(categories, atomCategory{Term: t})
+		}
+		af.Entries = append(af.Entries, atomEntry{
+			Title:      it.Title,
+			ID:         it.URL,
+			Link:       atomLink{Href: it.URL, Rel: "alternate", Type: "text/html"},
+			Published:  it.Published.Format(time.RFC3339),
+			Updated:    it.Updated.Format(time.RFC3339),
+			Categories: categories,
+			Content:    atomContent{Type: "html", Value: it.HTML},
+		})
+	}
+	return writeXML(w, af)
+}
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// --- JSON Feed 1.1 ---
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Authors     []jsonAuthor   `json:"authors,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html"`
+	DatePublished string   `json:"date_published"`
+	DateModified  string   `json:"date_modified"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+func (b *Builder) writeJSONFeed(w io.Writer, f feed) error {
+	jf := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.title,
+		HomePageURL: f.homeURL,
+		FeedURL:     b.config.absURL("/" + path.Join(f.dir, "feed.json")),
+		Items:       []jsonFeedItem{},
+	}
+	if b.config.Author != "" {
+		jf.Authors = []jsonAuthor{{Name: b.config.Author}}
+	}
+	for _, it := range f.items {
+		jf.Items = append(jf.Items, jsonFeedItem{
+			ID:            it.URL,
+			URL:           it.URL,
+			Title:         it.Title,
+			ContentHTML:   it.HTML,
+			DatePublished: it.Published.Format(time.RFC3339),
+			DateModified:  it.Updated.Format(time.RFC3339),
+			Tags:          it.Tags,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jf)
+}
@@ -0,0 +1,44 @@
+package site
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	footnoteItemRe     = regexp.MustCompile(`(?s)<li id="([^"]*)fn:(\d+)">\n(.*?)</li>\n`)
+	footnoteBacklinkRe = regexp.MustCompile(`&#160;<a [^>]*role="doc-backlink">.*?</a>`)
+	footnoteBlockRe    = regexp.MustCompile(`<(ul|ol|li|pre|div|blockquote|table|h[1-6]|dl|math display)[ >]`)
+)
+
+// sidenotes copies each footnote in rendered HTML next to its first
+// reference as a <span class="sidenote">, which style.css shows in the page
+// margin on wide screens. Footnotes holding more than paragraphs (lists,
+// code, ...) can't go in a span and stay at the bottom only; the rest are
+// marked has-sidenote so the list can hide them where sidenotes show.
+func sidenotes(html string) string {
+	for _, m := range footnoteItemRe.FindAllStringSubmatch(html, -1) {
+		prefix, index, content := m[1], m[2], m[3]
+		content = strings.TrimSpace(footnoteBacklinkRe.ReplaceAllString(content, ""))
+		if footnoteBlockRe.MatchString(content) {
+			continue
+		}
+		content = strings.TrimSuffix(strings.TrimPrefix(content, "<p>"), "</p>")
+		content = strings.ReplaceAll(content, "</p>\n<p>", "<br><br>")
+
+		ref := `<sup id="` + prefix + `fnref:` + index + `">`
+		at := strings.Index(html, ref)
+		if at < 0 {
+			continue
+		}
+		end := strings.Index(html[at:], "</sup>")
+		if end < 0 {
+			continue
+		}
+		end += at + len("</sup>")
+		note := `<span class="sidenote" role="note"><span class="sidenote-number">` + index + `</span> ` + content + `</span>`
+		html = html[:end] + note + html[end:]
+		html = strings.Replace(html, `<li id="`+prefix+`fn:`+index+`">`, `<li id="`+prefix+`fn:`+index+`" class="has-sidenote">`, 1)
+	}
+	return html
+}
@@ -0,0 +1,292 @@
+package site
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+	"github.com/nilszeilon/notesync/internal/search"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// The full-text index lives under /search/ and is loaded lazily by the
+// default templates' search script:
+//
+//	docs.json       document list: title, slug, date, group, tags, length
+//	terms-{s}.json  postings for terms starting with s (a-z, "0" for digits,
+//	                "_" for anything else): term -> [[doc, offset, ...], ...]
+//	text-{n}.json   plain text of docs n*textChunkSize and up, for snippets
+//
+// Each document's text is its title, a blank line, then the body as plain
+// text. Offsets are in UTF-16 code units, as JavaScript strings index them.
+const textChunkSize = 100
+
+type searchDocsFile struct {
+	Version   int              `json:"version"`
+	TextChunk int              `json:"textChunk"`
+	Docs      []searchDocEntry `json:"docs"`
+}
+
+type searchDocEntry struct {
+	Title string   `json:"title"`
+	Slug  string   `json:"slug"`
+	Date  string   `json:"date"`
+	Group string   `json:"group,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Len   int      `json:"len"` // number of indexed terms
+}
+
+// plainDoc is a note's searchable text and its postings, cached across
+// builds.
+type plainDoc struct {
+	key    string
+	text   string
+	terms  int                 // number of indexed terms
+	shards map[string]docShard // the note's part of each shard it has terms in
+}
+
+// docShard is a document's terms in one shard, in order of first appearance.
+type docShard struct {
+	key   string // hash of terms
+	terms []termPositions
+}
+
+// termPositions is a term of a document and the UTF-16 offsets it appears at.
+type termPositions struct {
+	term    string
+	offsets []int
+}
+
+// buildFullTextIndex writes the sharded inverted index for published notes.
+// Postings are kept per note between builds, and a shard or text chunk is
+// only encoded again when the postings or texts in it changed or a document
+// in it moved.
+func (b *Builder) buildFullTextIndex(notes []Note) error {
+	docs := searchDocsFile{Version: 1, TextChunk: textChunkSize, Docs: []searchDocEntry{}}
+	plain := make([]plainDoc, len(notes))
+	shards := make(map[string][]int) // shard -> ids of the documents with terms in it
+
+	live := make(map[string]bool, len(notes))
+	for id, n := range notes {
+		live[n.Slug] = true
+		doc := b.plainDoc(n)
+		plain[id] = doc
+		docs.Docs = append(docs.Docs, searchDocEntry{
+			Title: n.Title,
+			Slug:  n.Slug,
+			Date:  n.dateString(),
+			Group: n.Group,
+			Tags:  n.Tags,
+			Len:   doc.terms,
+		})
+		for shard := range doc.shards {
+			shards[shard] = append(shards[shard], id)
+		}
+	}
+	for slug := range b.plain {
+		if !live[slug] {
+			delete(b.plain, slug)
+		}
+	}
+
+	if err := b.emitJSON("search/docs.json", docs); err != nil {
+		return err
+	}
+	for shard, ids := range shards {
+		key := docsKey(ids, func(id int) string { return plain[id].shards[shard].key })
+		err := b.outputs.emit(path.Join("search", "terms-"+shard+".json"), key, func(w io.Writer) error {
+			terms := make(map[string][][]int)
+			for _, id := range ids {
+				for _, t := range plain[id].shards[shard].terms {
+					terms[t.term] = append(terms[t.term], append([]int{id}, t.offsets...))
+				}
+			}
+			return writeJSON(w, terms)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(plain); i += textChunkSize {
+		var ids []int
+		for id := i; id < min(i+textChunkSize, len(plain)); id++ {
+			ids = append(ids, id)
+		}
+		key := docsKey(ids, func(id int) string { return plain[id].key })
+		err := b.outputs.emit(path.Join("search", fmt.Sprintf("text-%d.json", i/textChunkSize)), key, func(w io.Writer) error {
+			var texts []string
+			for _, id := range ids {
+				texts = append(texts, plain[id].text)
+			}
+			return writeJSON(w, texts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// docsKey identifies a search file made from the documents ids by their
+// positions and the keys of their parts in it.
+func docsKey(ids []int, key func(id int) string) string {
+	h := sha256.New()
+	var buf []byte
+	for _, id := range ids {
+		buf = strconv.AppendInt(buf[:0], int64(id), 10)
+		buf = append(append(append(buf, ' '), key(id)...), '\n')
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeJSON writes v to w as compact JSON.
+func writeJSON(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// emitJSON writes v as compact JSON, keyed on the encoded bytes.
+func (b *Builder) emitJSON(rel string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.outputs.emit(rel, hashKey(data), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (b *Builder) plainDoc(n Note) plainDoc {
+	key := hashKey(n.Hash, n.Title)
+	if d, ok := b.plain[n.Slug]; ok && d.key == key {
+		return d
+	}
+	txt := n.Title + "\n\n" + b.plainText(n)
+	tokens := search.Tokenize(txt)
+	offsets := utf16Offsets(txt, tokens)
+	terms := make(map[string][]termPositions) // shard -> terms
+	seen := make(map[string]int)              // term -> index in its shard
+	for i, t := range tokens {
+		shard := termShard(t.Term)
+		j, ok := seen[t.Term]
+		if !ok {
+			j = len(terms[shard])
+			seen[t.Term] = j
+			terms[shard] = append(terms[shard], termPositions{term: t.Term})
+		}
+		terms[shard][j].offsets = append(terms[shard][j].offsets, offsets[i])
+	}
+	d := plainDoc{key: key, text: txt, terms: len(tokens), shards: make(map[string]docShard)}
+	for shard, ts := range terms {
+		h := sha256.New()
+		for _, t := range ts {
+			fmt.Fprintln(h, t.term, t.offsets)
+		}
+		d.shards[shard] = docShard{key: hex.EncodeToString(h.Sum(nil)), terms: ts}
+	}
+	b.plain[n.Slug] = d
+	return d
+}
+
+// plainText renders a note's body to plain text: wikilinks become their
+// display text, embeds and raw HTML are dropped, and blocks are separated by
+// newlines.
+func (b *Builder) plainText(n Note) string {
+	src := []byte(wikiLinksToText(n.Body))
+	doc := b.markdown(n).Parser().Parse(text.NewReader(src))
+
+	var sb strings.Builder
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			if node.Type() == ast.TypeBlock {
+				sb.WriteByte('\n')
+			}
+			return ast.WalkContinue, nil
+		}
+		switch n := node.(type) {
+		case *ast.Text:
+			sb.Write(n.Segment.Value(src))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(n.Value)
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				seg := lines.At(i)
+				sb.Write(seg.Value(src))
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return collapseBlankLines(sb.String())
+}
+
+// wikiLinksToText drops ![[embeds]] and block IDs and replaces wikilinks
+// with their display text.
+func wikiLinksToText(content string) string {
+	content = markdown.ObsidianEmbedRe.ReplaceAllString(content, "")
+	content = markdown.BlockIDRe.ReplaceAllString(content, "$1")
+	return markdown.WikilinkRe.ReplaceAllStringFunc(content, func(match string) string {
+		return markdown.ParseWikiLink(match[2 : len(match)-2]).Text()
+	})
+}
+
+func collapseBlankLines(s string) string {
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		out = append(out, l)
+	}
+	return strings.Join(out, "\n")
+}
+
+// termShard returns the shard a term's postings are stored in.
+func termShard(term string) string {
+	c := term[0]
+	switch {
+	case c >= 'a' && c <= 'z':
+		return string(c)
+	case c >= '0' && c <= '9':
+		return "0"
+	}
+	return "_"
+}
+
+// utf16Offsets converts the tokens' byte offsets into s to UTF-16 offsets.
+func utf16Offsets(s string, tokens []search.Token) []int {
+	offsets := make([]int, len(tokens))
+	byteOff, u16 := 0, 0
+	for i, t := range tokens {
+		for byteOff < t.Start {
+			r, size := utf8.DecodeRuneInString(s[byteOff:])
+			u16 += utf16.RuneLen(r)
+			byteOff += size
+		}
+		offsets[i] = u16
+	}
+	return offsets
+}
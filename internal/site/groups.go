@@ -0,0 +1,121 @@
+package site
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+)
+
+// noteGroup is a value of the group frontmatter field together with its
+// published notes.
+type noteGroup struct {
+	Name  string
+	Slug  string
+	Notes []Note
+
+	summary GroupSummary // for listings and the sidebar of the group's pages
+	key     string       // hash of summary, for the keys of pages showing it
+}
+
+// collectGroups groups notes by their group field. Notes within a group are
+// sorted by order/weight, with unordered notes after ordered ones in the
+// order they were passed in (newest first). Groups are sorted by their
+// lowest-ordered note, then by name; ungrouped notes come first. Like tags,
+// groups whose names differ only in case or punctuation are merged.
+func collectGroups(notes []Note) []noteGroup {
+	bySlug := make(map[string]*noteGroup)
+	var groups []*noteGroup
+	for _, n := range notes {
+		slug := groupSlug(n.Group)
+		g, ok := bySlug[slug]
+		if !ok {
+			g = &noteGroup{Name: n.Group, Slug: slug}
+			bySlug[slug] = g
+			groups = append(groups, g)
+		}
+		g.Notes = append(g.Notes, n)
+	}
+
+	for _, g := range groups {
+		sortByOrder(g.Notes)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		gi, gj := groups[i], groups[j]
+		if (gi.Name == "") != (gj.Name == "") {
+			return gi.Name == ""
+		}
+		if oi, oj := groupOrder(gi), groupOrder(gj); oi != oj {
+			return orderLess(oi, oj)
+		}
+		return strings.ToLower(gi.Name) < strings.ToLower(gj.Name)
+	})
+
+	out := make([]noteGroup, len(groups))
+	for i, g := range groups {
+		g.summary = GroupSummary{Name: g.Name, Slug: g.Slug, Notes: noteSummaries(g.Notes)}
+		g.key = hashKey(g.summary)
+		out[i] = *g
+	}
+	return out
+}
+
+// groupSlug is the path segment of a group's pages under /groups/. Names
+// without letters or digits to slugify, like "★" or "日本", get a hash of the
+// name instead. Notes without a group have an empty slug.
+func groupSlug(name string) string {
+	if name == "" {
+		return ""
+	}
+	if slug := markdown.Slugify(name); slug != "" {
+		return slug
+	}
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// sortByOrder stably sorts notes by order/weight. Zero (unset) sorts last.
+func sortByOrder(notes []Note) {
+	sort.SliceStable(notes, func(i, j int) bool {
+		return orderLess(notes[i].SortOrder(), notes[j].SortOrder())
+	})
+}
+
+func orderLess(a, b int) bool {
+	if (a == 0) != (b == 0) {
+		return b == 0
+	}
+	return a < b
+}
+
+// groupOrder is the order of a group's first note after sorting.
+func groupOrder(g *noteGroup) int {
+	if len(g.Notes) == 0 {
+		return 0
+	}
+	return g.Notes[0].SortOrder()
+}
+
+// group returns the named group, or nil for notes without a group.
+func (bc *buildContext) group(name string) *noteGroup {
+	if name == "" {
+		return nil
+	}
+	return bc.groupIndex[groupSlug(name)]
+}
+
+// buildGroupPages writes a listing per group at /groups/{group}/.
+func (b *Builder) buildGroupPages(bc *buildContext) error {
+	for _, g := range bc.groups {
+		if g.Slug == "" {
+			continue
+		}
+		if err := b.buildListing(g.Notes, path.Join("groups", g.Slug), g.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package site
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+)
+
+var (
+	htmlTagRe    = regexp.MustCompile(`<[^>]*>`)
+	mdLinkDestRe = regexp.MustCompile(`\]\([^)]*\)`)
+)
+
+// headingIDs generates heading ids with markdown.HeadingID, so that
+// [[note#Heading]] links find them. Repeated headings get -1, -2, ...
+// suffixes; links point at the first. Headings of embedded notes get a
+// prefix so they don't clash with the page's own.
+type headingIDs struct {
+	prefix string
+	used   map[string]bool
+}
+
+var _ parser.IDs = (*headingIDs)(nil)
+
+func newHeadingIDs(prefix string) *headingIDs {
+	return &headingIDs{prefix: prefix, used: make(map[string]bool)}
+}
+
+// Generate is given the heading's source line, after wikilinks have been
+// replaced with HTML.
+func (s *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	text := mdLinkDestRe.ReplaceAllString(htmlTagRe.ReplaceAllString(string(value), ""), "]")
+	id := s.prefix + markdown.HeadingID(text)
+	for i := 1; s.used[id]; i++ {
+		id = s.prefix + markdown.HeadingID(text) + "-" + strconv.Itoa(i)
+	}
+	s.used[id] = true
+	return []byte(id)
+}
+
+func (s *headingIDs) Put(value []byte) {
+	s.used[string(value)] = true
+}
+
+// collectHeadings lists the headings in doc, in order and not nested.
+func collectHeadings(doc ast.Node, src []byte) []TOCEntry {
+	var headings []TOCEntry
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := node.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		e := TOCEntry{Level: h.Level, Text: strings.TrimSpace(inlineText(h, src))}
+		if id, ok := h.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				e.ID = string(b)
+			}
+		}
+		if e.ID != "" && e.Text != "" {
+			headings = append(headings, e)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	return headings
+}
+
+// inlineText is the text of n's inline content, without markup or raw HTML.
+func inlineText(n ast.Node, src []byte) string {
+	var sb strings.Builder
+	ast.Walk(n, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch t := node.(type) {
+		case *ast.Text:
+			sb.Write(t.Segment.Value(src))
+			if t.SoftLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(t.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return sb.String()
+}
+
+// nestTOC nests each heading under the closest heading above it with a
+// lower level.
+func nestTOC(flat []TOCEntry) []TOCEntry {
+	var toc []TOCEntry
+	for i := 0; i < len(flat); {
+		e := flat[i]
+		j := i + 1
+		for j < len(flat) && flat[j].Level > e.Level {
+			j++
+		}
+		e.Children = nestTOC(flat[i+1 : j])
+		toc = append(toc, e)
+		i = j
+	}
+	return toc
+}
+
+// pageTOC returns the table of contents shown on n's page. Notes get one
+// when they have at least two headings; "toc: true" shows it for a single
+// heading too and "toc: false" hides it.
+func pageTOC(n Note, headings []TOCEntry) []TOCEntry {
+	min := 2
+	if n.TOC != nil {
+		if !*n.TOC {
+			return nil
+		}
+		min = 1
+	}
+	if len(headings) < min {
+		return nil
+	}
+	return nestTOC(headings)
+}
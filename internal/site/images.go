@@ -0,0 +1,462 @@
+package site
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nilszeilon/notesync/internal/imaging"
+	"github.com/nilszeilon/notesync/internal/markdown"
+)
+
+// defaultImageWidths are the widths, in pixels, of the resized copies made
+// of each JPEG and PNG image when Config.ImageWidths is nil.
+var defaultImageWidths = []int{480, 960, 1600}
+
+// imageSizes tells browsers how wide images are shown: the full width of the
+// content column, which is at most 48rem.
+const imageSizes = "(min-width: 52rem) 48rem, 100vw"
+
+// imageCacheDir holds processed images under the output directory, named by
+// the source's content hash, so rebuilds and renames don't redo the work.
+// The leading dot keeps cleanLegacyOutput away from it.
+const imageCacheDir = ".cache/images"
+
+// imageFormats maps image file extensions to the format names used by the
+// imaging package.
+var imageFormats = map[string]string{
+	".jpg": "jpeg", ".jpeg": "jpeg", ".png": "png",
+	".webp": "webp", ".gif": "gif", ".svg": "svg",
+}
+
+// imageInfo describes an image in the vault, along with the file stamp it
+// was read at.
+type imageInfo struct {
+	size    int64
+	modTime time.Time
+	hash    string // sha256 of the file
+	format  string
+	width   int // as displayed, after EXIF rotation; 0 if unknown
+	height  int
+}
+
+// imageVariant is one file published for an image: the image itself or a
+// resized or re-encoded copy of it.
+type imageVariant struct {
+	path   string // relative to the images/ output dir
+	width  int
+	format string
+}
+
+// scanImages records every image in the vault in b.images and indexes them
+// for resolving references. The first build walks the vault; later ones look
+// only at the files in changed, unless it is nil. Images whose size and
+// modtime match the previous build aren't read again.
+func (b *Builder) scanImages(changed map[string]bool) error {
+	if b.images == nil {
+		b.images = make(map[string]imageInfo)
+	}
+	added := b.imageIndex == nil
+	if changed == nil || !b.scanned {
+		seen := make(map[string]bool)
+		err := filepath.Walk(b.dataDir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !isImageFile(p) {
+				return nil
+			}
+			relPath, _ := filepath.Rel(b.dataDir, p)
+			rel := filepath.ToSlash(relPath)
+			seen[rel] = true
+			if _, ok := b.images[rel]; !ok {
+				added = true
+			}
+			return b.loadImage(rel, info)
+		})
+		if err != nil {
+			return err
+		}
+		for rel := range b.images {
+			if !seen[rel] {
+				delete(b.images, rel)
+				added = true
+			}
+		}
+	} else {
+		for rel := range changed {
+			if !isImageFile(rel) {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(b.dataDir, filepath.FromSlash(rel)))
+			if os.IsNotExist(err) || err == nil && info.IsDir() {
+				if _, ok := b.images[rel]; ok {
+					delete(b.images, rel)
+					added = true
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if _, ok := b.images[rel]; !ok {
+				added = true
+			}
+			if err := b.loadImage(rel, info); err != nil {
+				return err
+			}
+		}
+	}
+
+	attachments := b.config.AttachmentFolder
+	if attachments == "" {
+		attachments = markdown.AttachmentFolder(b.dataDir)
+	}
+	if !added && attachments == b.attachments {
+		return nil
+	}
+	paths := make([]string, 0, len(b.images))
+	for rel := range b.images {
+		paths = append(paths, rel)
+	}
+	b.imageIndex = markdown.NewImageIndex(paths, attachments)
+	b.imageRefs = make(map[imageRef]string)
+	b.imageGen++
+	b.attachments = attachments
+	return nil
+}
+
+func isImageFile(p string) bool {
+	_, ok := imageFormats[strings.ToLower(filepath.Ext(p))]
+	return ok
+}
+
+// loadImage reads the image at the vault path rel into b.images, unless its
+// size and modtime match the cached copy.
+func (b *Builder) loadImage(rel string, info os.FileInfo) error {
+	if img, ok := b.images[rel]; ok && img.size == info.Size() && img.modTime.Equal(info.ModTime()) {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(b.dataDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	format := imageFormats[strings.ToLower(filepath.Ext(rel))]
+	img := imageInfo{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		hash:    hex.EncodeToString(sum[:]),
+		format:  format,
+	}
+	if format != "svg" {
+		// Go by the contents, since extensions aren't always right.
+		format, img.width, img.height, err = imaging.Size(data)
+		if err != nil {
+			log.Printf("site build: read size of %s: %v", rel, err)
+		} else {
+			img.format = format
+		}
+	}
+	b.images[rel] = img
+	return nil
+}
+
+// resolveImage returns the vault path of the image ref in n points to. See
+// markdown.ImageIndex.Resolve for how references are resolved; a reference
+// to the image's URL on the site works too.
+func (b *Builder) resolveImage(n Note, ref string) (string, bool) {
+	key := imageRef{note: n.FilePath, ref: ref}
+	rel, ok := b.imageRefs[key]
+	if !ok {
+		if rel, ok = b.imageIndex.Resolve(n.FilePath, ref); !ok {
+			rel, _ = b.siteImage(ref)
+		}
+		b.imageRefs[key] = rel
+	}
+	return rel, rel != ""
+}
+
+// imageRef is an image reference as written in the note at the storage path
+// note.
+type imageRef struct {
+	note, ref string
+}
+
+// siteImage returns the vault path of the image published at the URL src.
+func (b *Builder) siteImage(src string) (string, bool) {
+	rel, ok := strings.CutPrefix(strings.TrimSpace(src), "/images/")
+	if !ok {
+		return "", false
+	}
+	rel, _ = url.PathUnescape(rel)
+	_, ok = b.images[rel]
+	return rel, ok
+}
+
+// imageStamp identifies the image ref in n points to by its content and
+// where it is published, so pages showing an image are rebuilt when either
+// changes.
+func (b *Builder) imageStamp(n Note, ref string, bc *buildContext) string {
+	if rel, ok := b.resolveImage(n, ref); ok {
+		return path.Join(bc.imageDir(rel), rel) + "@" + b.images[rel].hash
+	}
+	return ref
+}
+
+// imageWidths returns the configured variant widths.
+func (b *Builder) imageWidths() []int {
+	if b.config.ImageWidths == nil {
+		return defaultImageWidths
+	}
+	return b.config.ImageWidths
+}
+
+// imageVariants lists the files published for the image at rel: the image
+// itself first, then JPEG and PNG copies at each configured width narrower
+// than the image, then, for PNGs, lossless WebP copies at each of those
+// widths and at full size. GIFs, SVGs and WebPs are published as they are.
+func (b *Builder) imageVariants(rel string, img imageInfo) []imageVariant {
+	variants := []imageVariant{{path: rel, width: img.width, format: img.format}}
+	if img.format != "jpeg" && img.format != "png" || img.width == 0 {
+		return variants
+	}
+	base := strings.TrimSuffix(rel, path.Ext(rel))
+	var widths []int
+	for _, w := range b.imageWidths() {
+		if w > 0 && w < img.width {
+			widths = append(widths, w)
+			variants = append(variants, imageVariant{path: fmt.Sprintf("%s.%dw%s", base, w, path.Ext(rel)), width: w, format: img.format})
+		}
+	}
+	if img.format == "png" {
+		for _, w := range append(widths, img.width) {
+			variants = append(variants, imageVariant{path: fmt.Sprintf("%s.%dw.webp", base, w), width: w, format: "webp"})
+		}
+	}
+	return variants
+}
+
+// referencedImages returns the vault paths of the images notes embed.
+func (b *Builder) referencedImages(notes []Note) map[string]bool {
+	refs := make(map[string]bool)
+	for _, n := range notes {
+		for _, ref := range n.parsed.images {
+			if rel, ok := b.resolveImage(n, ref); ok {
+				refs[rel] = true
+			}
+		}
+	}
+	return refs
+}
+
+// publishImages writes the images published notes embed to images/, with their
+// metadata stripped and alongside their resized variants. Images only drafts
+// embed go to a secret folder under drafts/ instead. Other images in the
+// vault are never published, so private attachments stay private.
+// Processed images are cached by content hash; cache entries no build uses
+// are removed.
+func (b *Builder) publishImages(bc *buildContext) error {
+	cacheDir := filepath.Join(b.outDir, filepath.FromSlash(imageCacheDir))
+	used := make(map[string]bool)
+	for rel := range bc.images {
+		img := b.images[rel]
+		dir := bc.imageDir(rel)
+		src := filepath.Join(b.dataDir, filepath.FromSlash(rel))
+		if img.format == "gif" || img.format == "svg" {
+			info, err := os.Stat(src)
+			if err != nil {
+				return err
+			}
+			if err := b.outputs.emitFile(path.Join(dir, rel), src, info); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var decoded *image.RGBA
+		decode := func(data []byte) (*image.RGBA, error) {
+			if decoded != nil {
+				return decoded, nil
+			}
+			var err error
+			decoded, err = imaging.Decode(data)
+			return decoded, err
+		}
+		for i, v := range b.imageVariants(rel, img) {
+			name := fmt.Sprintf("%s-%d.%s", img.hash, v.width, v.format)
+			if i == 0 {
+				name = img.hash + "." + v.format
+			}
+			used[name] = true
+			cached := filepath.Join(cacheDir, name)
+			original := i == 0
+			err := b.outputs.emit(path.Join(dir, v.path), hashKey("image", name), func(w io.Writer) error {
+				if data, err := os.ReadFile(cached); err == nil {
+					_, err = w.Write(data)
+					return err
+				}
+				data, err := os.ReadFile(src)
+				if err != nil {
+					return err
+				}
+				out, err := processImage(data, img, v, original, decode)
+				if err != nil {
+					return fmt.Errorf("%w: %v", errBadImage, err)
+				}
+				if err := writeFileAtomic(cached, out); err != nil {
+					log.Printf("site build: cache image %s: %v", rel, err)
+				}
+				_, err = w.Write(out)
+				return err
+			})
+			if errors.Is(err, errBadImage) {
+				log.Printf("site build: skipping image %s: %v", rel, err)
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	entries, _ := os.ReadDir(cacheDir)
+	for _, e := range entries {
+		if !used[e.Name()] {
+			os.Remove(filepath.Join(cacheDir, e.Name()))
+		}
+	}
+	return nil
+}
+
+// errBadImage marks images that can't be processed. They are left out of the
+// site rather than failing the build.
+var errBadImage = errors.New("unreadable image")
+
+// processImage produces variant v of the image data. The original is
+// published with its metadata stripped; a JPEG that relies on its EXIF
+// orientation is re-encoded upright instead, since stripping would lose it.
+func processImage(data []byte, img imageInfo, v imageVariant, original bool, decode func([]byte) (*image.RGBA, error)) ([]byte, error) {
+	if original && (img.format != "jpeg" || imaging.Orientation(data) == 1) {
+		out, err := imaging.Strip(data, img.format)
+		if err != nil {
+			return nil, fmt.Errorf("strip metadata: %w", err)
+		}
+		return out, nil
+	}
+	pixels, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	var resized image.Image = pixels
+	if v.width != pixels.Bounds().Dx() {
+		resized = imaging.Resize(pixels, v.width)
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, resized, v.format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	imgTagRe  = regexp.MustCompile(`<img\s[^>]*>`)
+	imgSrcRe  = regexp.MustCompile(`\ssrc="([^"]*)"`)
+	imgAttrRe = regexp.MustCompile(`\s(width|height|srcset|loading|decoding)=`)
+)
+
+// responsiveImages adds dimensions, lazy loading and a srcset of the
+// resized variants to the published images in rendered HTML. PNGs get a
+// <picture> preferring their WebP copies.
+func (b *Builder) responsiveImages(n Note, out string, bc *buildContext) string {
+	return imgTagRe.ReplaceAllStringFunc(out, func(tag string) string {
+		m := imgSrcRe.FindStringSubmatch(tag)
+		if m == nil {
+			return tag
+		}
+		src := html.UnescapeString(m[1])
+		if u, err := url.Parse(src); err != nil || u.Scheme != "" || u.Host != "" {
+			return tag
+		}
+		rel, ok := b.siteImage(src)
+		if !ok {
+			rel, ok = b.resolveImage(n, src)
+		}
+		if !ok || !bc.images[rel] {
+			return tag
+		}
+		img := b.images[rel]
+
+		present := make(map[string]bool)
+		for _, a := range imgAttrRe.FindAllStringSubmatch(tag, -1) {
+			present[a[1]] = true
+		}
+		if present["srcset"] {
+			return tag
+		}
+		var srcset, webp []string
+		for _, v := range b.imageVariants(rel, img) {
+			entry := bc.imageURL(rel, v.path) + " " + strconv.Itoa(v.width) + "w"
+			if v.format == "webp" && img.format != "webp" {
+				webp = append(webp, entry)
+			} else {
+				srcset = append(srcset, entry)
+			}
+		}
+		// The original comes first in the list; srcset lists widths in order.
+		srcset = append(srcset[1:], srcset[0])
+
+		var attrs []string
+		if img.width > 0 && !present["width"] && !present["height"] {
+			attrs = append(attrs, fmt.Sprintf(`width="%d" height="%d"`, img.width, img.height))
+		}
+		if len(srcset) > 1 {
+			attrs = append(attrs, `srcset="`+html.EscapeString(strings.Join(srcset, ", "))+`"`, `sizes="`+imageSizes+`"`)
+		}
+		if !present["loading"] {
+			attrs = append(attrs, `loading="lazy"`)
+		}
+		if !present["decoding"] {
+			attrs = append(attrs, `decoding="async"`)
+		}
+
+		tag = strings.Replace(tag, m[0], ` src="`+html.EscapeString(bc.imageURL(rel, rel))+`"`, 1)
+		end := len(tag) - 1
+		if strings.HasSuffix(tag, "/>") {
+			end = len(tag) - 2
+		}
+		tag = strings.TrimRight(tag[:end], " ") + " " + strings.Join(attrs, " ") + tag[end:]
+		if len(webp) == 0 {
+			return tag
+		}
+		return `<picture><source type="image/webp" srcset="` + html.EscapeString(strings.Join(webp, ", ")) + `" sizes="` + imageSizes + `">` + tag + `</picture>`
+	})
+}
+
+// imageDir returns the output folder the image at rel is published in.
+func (bc *buildContext) imageDir(rel string) string {
+	if dir, ok := bc.imageDirs[rel]; ok {
+		return dir
+	}
+	return "images"
+}
+
+// imageURL returns the site URL of the file p published for the image at
+// rel: the image itself when p is rel, or one of its variants.
+func (bc *buildContext) imageURL(rel, p string) string {
+	return (&url.URL{Path: "/" + bc.imageDir(rel) + "/" + p}).EscapedPath()
+}
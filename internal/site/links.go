@@ -0,0 +1,177 @@
+package site
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+)
+
+// linkResolver resolves wikilink targets against every note in the vault the
+// way Obsidian does: by path ("projects/plan"), by file name when that is
+// unambiguous or by the closest match when it isn't, and by aliases.
+type linkResolver struct {
+	byPath  map[string]Note   // lowercased path without .md
+	byName  map[string][]Note // lowercased file name without .md
+	byAlias map[string][]Note // lowercased alias
+	bySlug  map[string][]Note // slugified file name, so [[some note]] finds some-note.md
+	byFile  map[string]Note   // storage path -> note
+	live    map[string]Note   // slug -> note, for notes that get a page
+
+	// Targets only resolve differently once a note is added, removed,
+	// renamed or given other aliases, so resolved targets are remembered
+	// from build to build until then.
+	key  string             // hash of every note's path and aliases
+	memo map[linkRef]string // -> storage path of the target, "" if none
+}
+
+// linkRef is a link target as written in the note at the storage path from.
+type linkRef struct {
+	from, target string
+}
+
+// newLinkResolver indexes notes. It reuses what prev, the previous build's
+// resolver, resolved if no note was added, removed, renamed or re-aliased
+// since.
+func newLinkResolver(notes []Note, live map[string]Note, prev *linkResolver) *linkResolver {
+	r := &linkResolver{
+		byPath:  make(map[string]Note),
+		byName:  make(map[string][]Note),
+		byAlias: make(map[string][]Note),
+		bySlug:  make(map[string][]Note),
+		byFile:  make(map[string]Note),
+		live:    live,
+	}
+	names := make([]string, 0, len(notes))
+	for _, n := range notes {
+		names = append(names, n.FilePath+"\x00"+strings.Join(n.Aliases, "\x00"))
+	}
+	sort.Strings(names)
+	r.key = hashKey(names)
+	if prev != nil && prev.key == r.key {
+		r.memo = prev.memo
+	} else {
+		r.memo = make(map[linkRef]string)
+	}
+
+	for _, n := range notes {
+		r.byFile[n.FilePath] = n
+		p := notePath(n)
+		name := path.Base(p)
+		r.byPath[p] = n
+		r.byName[name] = append(r.byName[name], n)
+		r.bySlug[markdown.Slugify(name)] = append(r.bySlug[markdown.Slugify(name)], n)
+		for _, a := range n.Aliases {
+			a = strings.ToLower(strings.TrimSpace(a))
+			r.byAlias[a] = append(r.byAlias[a], n)
+		}
+	}
+	return r
+}
+
+// notePath is a note's lowercased storage path without the .md extension.
+func notePath(n Note) string {
+	p := strings.ToLower(path.Clean("/" + strings.ReplaceAll(n.FilePath, "\\", "/")))
+	return strings.TrimSuffix(p[1:], ".md")
+}
+
+// resolve returns the note target refers to when linked from the note from,
+// whether or not it is published.
+func (r *linkResolver) resolve(target string, from Note) (Note, bool) {
+	ref := linkRef{from: from.FilePath, target: target}
+	file, ok := r.memo[ref]
+	if !ok {
+		if n, found := r.find(target, from); found {
+			file = n.FilePath
+		}
+		r.memo[ref] = file
+	}
+	n, ok := r.byFile[file]
+	return n, ok
+}
+
+func (r *linkResolver) find(target string, from Note) (Note, bool) {
+	t := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(target, "\\", "/")))
+	t = strings.TrimSuffix(strings.Trim(t, "/"), ".md")
+	if t == "" {
+		return Note{}, false
+	}
+
+	if strings.Contains(t, "/") {
+		if n, ok := r.byPath[t]; ok {
+			return n, true
+		}
+		// A partial path matches the end of a note's path.
+		var matches []Note
+		for p, n := range r.byPath {
+			if strings.HasSuffix(p, "/"+t) {
+				matches = append(matches, n)
+			}
+		}
+		return closest(matches, from)
+	}
+
+	for _, candidates := range [][]Note{r.byName[t], r.byAlias[t], r.bySlug[markdown.Slugify(t)]} {
+		if n, ok := closest(candidates, from); ok {
+			return n, true
+		}
+	}
+	return Note{}, false
+}
+
+// closest picks among notes sharing a name: one in the linking note's folder
+// if there is one, otherwise the one with the shortest path.
+func closest(candidates []Note, from Note) (Note, bool) {
+	if len(candidates) == 0 {
+		return Note{}, false
+	}
+	dir := path.Dir(notePath(from))
+	sorted := append([]Note(nil), candidates...)
+	sort.Slice(sorted, func(i, j int) bool {
+		pi, pj := notePath(sorted[i]), notePath(sorted[j])
+		if si, sj := path.Dir(pi) == dir, path.Dir(pj) == dir; si != sj {
+			return si
+		}
+		if len(pi) != len(pj) {
+			return len(pi) < len(pj)
+		}
+		return pi < pj
+	})
+	return sorted[0], true
+}
+
+// href returns the URL of the page l links to from the note from, or false
+// if the target doesn't exist or isn't published.
+func (r *linkResolver) href(l markdown.WikiLink, from Note) (string, bool) {
+	anchor := ""
+	switch {
+	case l.Block != "":
+		anchor = "#^" + l.Block
+	case l.Heading != "":
+		anchor = "#" + markdown.HeadingID(l.Heading)
+	}
+
+	if l.Target == "" {
+		return anchor, anchor != ""
+	}
+	n, ok := r.resolve(l.Target, from)
+	if !ok {
+		return "", false
+	}
+	if _, ok := r.live[n.Slug]; !ok {
+		return "", false
+	}
+	if n.Slug == from.Slug && anchor != "" {
+		return anchor, true
+	}
+	return noteURL(n) + anchor, true
+}
+
+// noteURL is the path a note's page is served at.
+func noteURL(n Note) string {
+	if n.Slug == "home" {
+		return "/"
+	}
+	return "/" + n.Slug
+}
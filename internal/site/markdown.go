@@ -0,0 +1,100 @@
+package site
+
+import (
+	"github.com/nilszeilon/notesync/internal/mdext"
+	"github.com/yuin/goldmark"
+	emoji "github.com/yuin/goldmark-emoji"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// markdownFeatures are the optional markdown extensions. Config sets them for
+// the whole site and a note's frontmatter can override them.
+type markdownFeatures struct {
+	math            bool
+	footnotes       bool
+	sidenotes       bool // footnotes are also shown in the margin
+	definitionLists bool
+	typographer     bool
+	emoji           bool
+}
+
+// footnotePrefixKey is the document metadata key holding the prefix for
+// footnote ids, so footnotes in embedded notes don't clash with the page's.
+const footnotePrefixKey = "footnote-prefix"
+
+func footnotePrefix(n ast.Node) []byte {
+	prefix, _ := n.OwnerDocument().Meta()[footnotePrefixKey].(string)
+	return []byte(prefix)
+}
+
+func newMarkdown(f markdownFeatures) goldmark.Markdown {
+	exts := []goldmark.Extender{extension.GFM, mdext.Callouts, mdext.Highlights, mdext.CodeHighlighting, mdext.HeadingAnchors}
+	if f.math {
+		exts = append(exts, mdext.Math)
+	}
+	if f.footnotes {
+		exts = append(exts, extension.NewFootnote(
+			extension.WithFootnoteIDPrefixFunction(footnotePrefix),
+		))
+	}
+	if f.definitionLists {
+		exts = append(exts, extension.DefinitionList)
+	}
+	if f.typographer {
+		exts = append(exts, extension.Typographer)
+	}
+	if f.emoji {
+		exts = append(exts, emoji.New(emoji.WithRenderingMethod(emoji.Unicode)))
+	}
+	return goldmark.New(
+		goldmark.WithExtensions(exts...),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithRendererOptions(
+			html.WithUnsafe(),
+		),
+	)
+}
+
+// features returns the markdown features n is rendered with.
+func (b *Builder) features(n Note) markdownFeatures {
+	c := b.config
+	f := markdownFeatures{
+		math:            !c.DisableMath,
+		footnotes:       !c.DisableFootnotes,
+		sidenotes:       c.Sidenotes,
+		definitionLists: !c.DisableDefinitionLists,
+		typographer:     !c.DisableTypographer,
+		emoji:           !c.DisableEmoji,
+	}
+	override(&f.math, n.Math)
+	override(&f.footnotes, n.Footnotes)
+	override(&f.sidenotes, n.Sidenotes)
+	override(&f.definitionLists, n.DefinitionLists)
+	override(&f.typographer, n.Typographer)
+	override(&f.emoji, n.Emoji)
+	return f
+}
+
+// override sets *v to the frontmatter value fm, if the note has one.
+func override(v *bool, fm *bool) {
+	if fm != nil {
+		*v = *fm
+	}
+}
+
+// markdown returns the goldmark instance that renders n.
+func (b *Builder) markdown(n Note) goldmark.Markdown {
+	f := b.features(n)
+	f.sidenotes = false // applied after rendering; see sidenotes
+	md, ok := b.md[f]
+	if !ok {
+		md = newMarkdown(f)
+		b.md[f] = md
+	}
+	return md
+}
@@ -0,0 +1,160 @@
+package site
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// outputSet tracks the files written into the output directory together with
+// a key describing the inputs each was generated from. A file whose key is
+// unchanged since the previous build is left untouched.
+type outputSet struct {
+	root    string
+	keys    map[string]string // rel path -> input key
+	written map[string]bool   // rel paths produced by the current build
+	changed map[string]bool   // rel paths written or removed by the current build
+}
+
+func newOutputSet(root string) *outputSet {
+	return &outputSet{root: root, keys: make(map[string]string)}
+}
+
+// clone returns a copy of o rooted at root, for a staging directory that
+// starts out with the same files as o's root.
+func (o *outputSet) clone(root string) *outputSet {
+	c := newOutputSet(root)
+	for rel, key := range o.keys {
+		c.keys[rel] = key
+	}
+	return c
+}
+
+func (o *outputSet) begin() {
+	o.written = make(map[string]bool)
+	o.changed = make(map[string]bool)
+}
+
+// emit makes sure rel exists in the output directory, calling gen to produce
+// its contents only if key differs from the one recorded for the last write.
+// The directory is trusted to hold the files the keys describe, so unchanged
+// outputs cost no file system access.
+func (o *outputSet) emit(rel, key string, gen func(w io.Writer) error) error {
+	o.written[rel] = true
+	dest := filepath.Join(o.root, rel)
+	if k, ok := o.keys[rel]; ok && k == key {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := gen(&buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(dest, buf.Bytes()); err != nil {
+		return err
+	}
+	o.keys[rel] = key
+	o.changed[rel] = true
+	return nil
+}
+
+// writtenDirs returns the folders holding the files written so far by the
+// current build.
+func (o *outputSet) writtenDirs() map[string]bool {
+	dirs := make(map[string]bool)
+	for rel := range o.written {
+		for d := path.Dir(filepath.ToSlash(rel)); d != "." && !dirs[d]; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	return dirs
+}
+
+// emitFile copies src to rel, keyed on the source file's size and modtime.
+func (o *outputSet) emitFile(rel, src string, info os.FileInfo) error {
+	key := hashKey(info.Size(), info.ModTime().UnixNano())
+	return o.emit(rel, key, func(w io.Writer) error {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// prune removes outputs from earlier builds that the current build did not
+// produce, along with any directories left empty.
+func (o *outputSet) prune() {
+	var stale []string
+	for rel := range o.keys {
+		if !o.written[rel] {
+			stale = append(stale, rel)
+		}
+	}
+	sort.Strings(stale)
+	for _, rel := range stale {
+		delete(o.keys, rel)
+		removeOutput(o.root, rel)
+		o.changed[rel] = true
+	}
+}
+
+// removeOutput removes rel from root, along with any directories left empty.
+func removeOutput(root, rel string) {
+	path := filepath.Join(root, rel)
+	os.Remove(path)
+	for dir := filepath.Dir(path); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break // not empty
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into
+// place, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".notesync-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// hashKey derives a stable key from arbitrary JSON-encodable values.
+func hashKey(parts ...any) string {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, p := range parts {
+		enc.Encode(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,223 @@
+package site
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The output directory holds one directory per build under builds/, and a
+// "current" symlink pointing at the live one:
+//
+//	_site/
+//	  current -> builds/20250115-093000.000000000
+//	  builds/
+//	    20250115-092500.000000000/   previous build, kept for in-flight requests
+//	    20250115-093000.000000000/
+//	    20250115-093000.000000000.access.json   who may read its protected files
+//
+// A build is written into a staging directory and published by atomically
+// replacing the symlink, so readers only ever see complete builds. The
+// previous build's directory is reused as the next staging directory, so the
+// builds take turns.
+const (
+	buildsDir   = "builds"
+	currentLink = "current"
+)
+
+// LiveDir returns the path that serves the most recently published build.
+func (b *Builder) LiveDir() string {
+	return filepath.Join(b.outDir, currentLink)
+}
+
+// liveBuildDir resolves the current symlink, or returns "" if nothing has
+// been published yet.
+func (b *Builder) liveBuildDir() string {
+	target, err := os.Readlink(b.LiveDir())
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(b.outDir, target)
+	}
+	return target
+}
+
+// stage returns the directory to generate the next build in, and whether it
+// is the spare build reused rather than a new directory. A full build starts
+// from an empty directory. Otherwise the spare build, which differs from the
+// live one only in the outputs listed in b.behind, is brought up to date by
+// linking those over, so staging costs as much as the last build changed
+// rather than the size of the site. Without a spare, a new directory is
+// seeded with hard links to all of the live build's files. Outputs are
+// always replaced via rename, never written in place, so the live build's
+// files are never modified through these links.
+func (b *Builder) stage(full bool) (string, bool, error) {
+	live := b.liveBuildDir()
+	if !full && live != "" && b.spare != "" {
+		err := b.catchUp(b.spare, live)
+		if err == nil {
+			return b.spare, true, nil
+		}
+		log.Printf("site build: reuse %s: %v (starting a new build directory)", b.spare, err)
+		b.spare = ""
+	}
+
+	dir := filepath.Join(b.outDir, buildsDir, time.Now().UTC().Format("20060102-150405.000000000"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", false, err
+	}
+	if full || live == "" {
+		return dir, false, nil
+	}
+	if err := linkTree(live, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", false, fmt.Errorf("seed from live build: %w", err)
+	}
+	return dir, false, nil
+}
+
+// catchUp makes the outputs in b.behind in dir the same as in live.
+func (b *Builder) catchUp(dir, live string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	for rel := range b.behind {
+		src := filepath.Join(live, rel)
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			removeOutput(dir, rel)
+			continue
+		}
+		if err := linkFile(src, filepath.Join(dir, rel)); err != nil {
+			return err
+		}
+	}
+	b.behind = nil
+	return nil
+}
+
+// unstage drops the staging directory of a build that wasn't published. The
+// spare build is kept, noting the outputs the build changed in it.
+func (b *Builder) unstage(dir string, reused bool) {
+	if !reused {
+		os.RemoveAll(dir)
+		os.Remove(dir + accessSuffix)
+		return
+	}
+	if b.behind == nil {
+		b.behind = make(map[string]bool)
+	}
+	for rel := range b.outputs.changed {
+		b.behind[rel] = true
+	}
+}
+
+// publish points the current symlink at dir and removes builds older than
+// the one it replaced.
+func (b *Builder) publish(dir string) error {
+	prev := b.liveBuildDir()
+
+	rel, err := filepath.Rel(b.outDir, dir)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(b.outDir, ".current-tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(rel, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.LiveDir()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(b.outDir, buildsDir))
+	for _, e := range entries {
+		path := filepath.Join(b.outDir, buildsDir, e.Name())
+		if build := strings.TrimSuffix(path, accessSuffix); build != dir && build != prev {
+			os.RemoveAll(path)
+		}
+	}
+	return nil
+}
+
+// cleanLegacyOutput removes site files written directly into the output
+// directory by versions that didn't stage builds. The output directory itself
+// is kept, since it may be a mount point.
+func (b *Builder) cleanLegacyOutput() error {
+	if err := os.MkdirAll(b.outDir, 0755); err != nil {
+		return fmt.Errorf("create output dir: %w", err)
+	}
+	entries, err := os.ReadDir(b.outDir)
+	if err != nil {
+		return fmt.Errorf("read output dir: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if name == buildsDir || name == currentLink || strings.HasPrefix(name, ".") {
+			continue
+		}
+		os.RemoveAll(filepath.Join(b.outDir, name))
+	}
+	return nil
+}
+
+// linkTree recreates src's directory structure under dst, hard-linking every
+// file. It falls back to copying where hard links aren't supported.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+// linkFile replaces dst with a hard link to src, or a copy of it.
+func linkFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(dst), ".notesync-link-"+filepath.Base(dst))
+	os.Remove(tmp)
+	if err := os.Link(src, tmp); err != nil {
+		if err := copyFile(src, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,210 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// movesFile records the published notes' slugs between builds, so that the
+// builder can tell when a note moved and redirect its old URL. It lives in
+// the output directory, outside the builds, so it survives restarts.
+const movesFile = ".redirects.json"
+
+// goneTTL is how long a note that disappeared is remembered in case it shows
+// up at another path: a rename can reach the server as a delete and an
+// upload that land in different builds.
+const goneTTL = 30 * 24 * time.Hour
+
+// moveState is what the builder remembers about published notes to detect
+// moves.
+type moveState struct {
+	Notes     map[string]noteRecord `json:"notes"`     // file path -> note, as of the last build
+	Gone      map[string]noteRecord `json:"gone"`      // content hash -> note no longer at its path
+	Redirects map[string]string     `json:"redirects"` // old slug -> slug the note moved to
+}
+
+type noteRecord struct {
+	Slug string    `json:"slug"`
+	Hash string    `json:"hash"`
+	Gone time.Time `json:"gone,omitzero"`
+}
+
+// loadMoves reads the move state saved by the previous build, if any.
+func (b *Builder) loadMoves() *moveState {
+	s := &moveState{}
+	if data, err := os.ReadFile(filepath.Join(b.outDir, movesFile)); err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			log.Printf("%s: %v (starting over)", movesFile, err)
+			s = &moveState{}
+		}
+	}
+	if s.Notes == nil {
+		s.Notes = make(map[string]noteRecord)
+	}
+	if s.Gone == nil {
+		s.Gone = make(map[string]noteRecord)
+	}
+	if s.Redirects == nil {
+		s.Redirects = make(map[string]string)
+	}
+	return s
+}
+
+// saveMoves writes the move state for the next build, if it changed.
+func (b *Builder) saveMoves() error {
+	data, err := json.MarshalIndent(b.moves, "", "  ")
+	if err != nil || bytes.Equal(data, b.movesSaved) {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(b.outDir, movesFile), data); err != nil {
+		return err
+	}
+	b.movesSaved = data
+	return nil
+}
+
+// track compares notes with the notes of the previous build and records a
+// redirect for every note whose slug changed: in place, through its slug or
+// permalink, or by reappearing with the same content at another path.
+func (s *moveState) track(notes []Note, now time.Time) {
+	current := make(map[string]Note, len(notes))
+	for _, n := range notes {
+		current[n.FilePath] = n
+	}
+	for p, old := range s.Notes {
+		if _, ok := current[p]; !ok {
+			old.Gone = now
+			s.Gone[old.Hash] = old
+		}
+	}
+	for p, n := range current {
+		if old, ok := s.Notes[p]; ok {
+			s.moved(old.Slug, n.Slug)
+		} else if old, ok := s.Gone[n.Hash]; ok {
+			s.moved(old.Slug, n.Slug)
+			delete(s.Gone, n.Hash)
+		}
+	}
+	for hash, old := range s.Gone {
+		if now.Sub(old.Gone) > goneTTL {
+			delete(s.Gone, hash)
+		}
+	}
+
+	s.Notes = make(map[string]noteRecord, len(current))
+	for p, n := range current {
+		s.Notes[p] = noteRecord{Slug: n.Slug, Hash: n.Hash}
+	}
+	// A page at an old URL replaces its redirect.
+	for _, n := range current {
+		delete(s.Redirects, n.Slug)
+	}
+}
+
+// moved redirects from, and the URLs that redirected to it, to to.
+func (s *moveState) moved(from, to string) {
+	if from == to {
+		return
+	}
+	for old, target := range s.Redirects {
+		if target == from {
+			s.Redirects[old] = to
+		}
+	}
+	s.Redirects[from] = to
+	delete(s.Redirects, to)
+}
+
+// pageURL is the URL of a note's page, with the trailing slash.
+func pageURL(n Note) string {
+	if n.Slug == "home" {
+		return "/"
+	}
+	return "/" + n.Slug + "/"
+}
+
+// buildRedirects writes a redirect page at every old URL of a published note:
+// those it lists in redirect_from or as path aliases, and those it had before
+// it moved. The server answers them with 301s; the pages cover other hosts.
+// An old URL naming a file, like "2019/01/post.html", gets the page at that
+// path; any other gets a folder with an index.html. It runs after every
+// other file of the build is written, so a redirect never takes the place of
+// one of them.
+func (b *Builder) buildRedirects(bc *buildContext) error {
+	redirects := make(map[string]string) // old path -> URL
+	for from, to := range b.moves.Redirects {
+		if n, ok := bc.slugIndex[to]; ok {
+			redirects[from] = pageURL(n)
+		}
+	}
+	for _, n := range bc.slugIndex {
+		for _, from := range n.RedirectPaths() {
+			redirects[from] = pageURL(n)
+		}
+	}
+
+	served := make(map[string]string)
+	dirs := b.outputs.writtenDirs()
+	for from, to := range redirects {
+		if _, ok := bc.slugIndex[from]; ok || b.outputs.written[from] || dirs[from] {
+			log.Printf("not redirecting /%s to %s: the site has a file there", from, to)
+			continue
+		}
+		target := b.config.absURL(to)
+		page := fmt.Sprintf(redirectPage, html.EscapeString(target), html.EscapeString(target), html.EscapeString(to), html.EscapeString(to))
+		out, paths := path.Join(from, "index.html"), []string{"/" + from, "/" + from + "/", "/" + from + "/index.html"}
+		if path.Ext(from) != "" {
+			out, paths = from, []string{"/" + from}
+		}
+		err := b.outputs.emit(out, hashKey("redirect", page), func(w io.Writer) error {
+			_, err := io.WriteString(w, page)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			served[p] = to
+		}
+	}
+
+	bc.redirects = served
+	return nil
+}
+
+const redirectPage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<title>Moved</title>
+	<link rel="canonical" href="%s">
+	<meta name="robots" content="noindex">
+	<meta http-equiv="refresh" content="0; url=%s">
+</head>
+<body>
+	<p>This page has moved to <a href="%s">%s</a>.</p>
+</body>
+</html>
+`
+
+// Redirect returns the URL the old URL path p, as requested (escaped),
+// redirects to as of the last build.
+func (b *Builder) Redirect(p string) (string, bool) {
+	p, err := url.PathUnescape(p)
+	if err != nil {
+		return "", false
+	}
+	b.redirectMu.Lock()
+	defer b.redirectMu.Unlock()
+	to, ok := b.redirects[p]
+	return to, ok
+}
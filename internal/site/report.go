@@ -0,0 +1,295 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+)
+
+// Report lists content problems found while building the site. Broken links,
+// missing images and duplicate slugs are errors; links to unpublished notes
+// and orphaned notes are warnings. It also lists the draft previews, whose
+// URLs aren't linked from anywhere else. Paths are storage paths of the notes.
+type Report struct {
+	Generated        time.Time      `json:"generated"`
+	Notes            int            `json:"notes"`
+	BrokenLinks      []LinkProblem  `json:"broken_links"`
+	UnpublishedLinks []LinkProblem  `json:"unpublished_links"`
+	MissingImages    []ImageProblem `json:"missing_images"`
+	Orphans          []string       `json:"orphans"`
+	DuplicateSlugs   []SlugConflict `json:"duplicate_slugs"`
+	Drafts           []DraftPreview `json:"drafts"`
+}
+
+// LinkProblem is a wikilink or note embed in a published note that doesn't
+// lead to a published page.
+type LinkProblem struct {
+	Source string `json:"source"`
+	Link   string `json:"link"`             // as written, without brackets or '!'
+	Target string `json:"target,omitempty"` // the note it resolved to, if any
+	Reason string `json:"reason"`
+}
+
+// ImageProblem is an image embedded in a published note that doesn't exist.
+type ImageProblem struct {
+	Source string `json:"source"`
+	Image  string `json:"image"`
+}
+
+// SlugConflict is a set of published notes that map to the same URL.
+type SlugConflict struct {
+	Slug  string   `json:"slug"`
+	Paths []string `json:"paths"`
+}
+
+// DraftPreview is the secret URL a draft note is shown at.
+type DraftPreview struct {
+	Source string `json:"source"`
+	URL    string `json:"url"`
+}
+
+// Errors returns the number of problems that break the published site.
+func (r *Report) Errors() int {
+	return len(r.BrokenLinks) + len(r.MissingImages) + len(r.DuplicateSlugs)
+}
+
+// Warnings returns the number of problems worth a look that don't break
+// anything.
+func (r *Report) Warnings() int {
+	return len(r.UnpublishedLinks) + len(r.Orphans)
+}
+
+// Report returns the report of the most recent build, or nil before the
+// first build.
+func (b *Builder) Report() *Report {
+	b.reportMu.Lock()
+	defer b.reportMu.Unlock()
+	return b.report
+}
+
+// WriteReportHTML renders the most recent build report with report.html.
+func (b *Builder) WriteReportHTML(w io.Writer) error {
+	b.reportMu.Lock()
+	r, tmpl := b.report, b.reportTmpl
+	b.reportMu.Unlock()
+	if r == nil {
+		return fmt.Errorf("no build report yet")
+	}
+	return tmpl.ExecuteTemplate(w, "report.html", r)
+}
+
+// checkContent builds the report for the published notes in bc. notes is
+// every note in the vault, published or not.
+func (b *Builder) checkContent(notes []Note, bc *buildContext) *Report {
+	r := &Report{
+		Generated:        time.Now().UTC(),
+		BrokenLinks:      []LinkProblem{},
+		UnpublishedLinks: []LinkProblem{},
+		MissingImages:    []ImageProblem{},
+		Orphans:          []string{},
+		DuplicateSlugs:   []SlugConflict{},
+		Drafts:           []DraftPreview{},
+	}
+	for _, n := range bc.drafts {
+		r.Drafts = append(r.Drafts, DraftPreview{Source: n.FilePath, URL: b.draftURL(n)})
+	}
+	sort.Slice(r.Drafts, func(i, j int) bool { return r.Drafts[i].Source < r.Drafts[j].Source })
+
+	bySlug := make(map[string][]string)
+	var checked []Note
+	for _, n := range notes {
+		if _, ok := bc.slugIndex[n.Slug]; ok && (n.LiveAt(bc.now) || n.Slug == "home") {
+			bySlug[n.Slug] = append(bySlug[n.Slug], n.FilePath)
+			checked = append(checked, n)
+		}
+	}
+	sort.Slice(checked, func(i, j int) bool { return checked[i].FilePath < checked[j].FilePath })
+	r.Notes = len(checked)
+
+	for slug, paths := range bySlug {
+		if len(paths) > 1 {
+			sort.Strings(paths)
+			r.DuplicateSlugs = append(r.DuplicateSlugs, SlugConflict{Slug: slug, Paths: paths})
+		}
+	}
+	sort.Slice(r.DuplicateSlugs, func(i, j int) bool { return r.DuplicateSlugs[i].Slug < r.DuplicateSlugs[j].Slug })
+
+	checks := make(map[string]noteCheck)
+	for _, n := range checked {
+		c, ok := b.checks[n.FilePath]
+		if !ok || !c.current(n, bc, b.imageGen) {
+			c = b.checkNote(n, bc)
+		}
+		checks[n.FilePath] = c
+		r.BrokenLinks = append(r.BrokenLinks, c.broken...)
+		r.UnpublishedLinks = append(r.UnpublishedLinks, c.unpublished...)
+		r.MissingImages = append(r.MissingImages, c.missing...)
+
+		if n.Slug != "home" && len(backlinkSummaries(n, bc)) == 0 {
+			r.Orphans = append(r.Orphans, n.FilePath)
+		}
+	}
+	b.checks = checks
+	return r
+}
+
+// noteCheck is what checkContent found wrong with a note's links and images.
+// It is kept for the next build, which reuses it unless the note, a note it
+// links to or the set of notes and images changed.
+type noteCheck struct {
+	hash    string // of the note
+	links   string // key of the link resolver
+	images  int    // Builder.imageGen
+	targets []checkedTarget
+
+	broken      []LinkProblem
+	unpublished []LinkProblem
+	missing     []ImageProblem
+}
+
+// checkedTarget is a note a checked note links to, as it was when checked.
+type checkedTarget struct {
+	file      string
+	hash      string
+	published bool
+}
+
+// checkNote checks n's links and images.
+func (b *Builder) checkNote(n Note, bc *buildContext) noteCheck {
+	c := noteCheck{hash: n.Hash, links: bc.links.key, images: b.imageGen}
+	for _, ref := range n.parsed.refs {
+		l := markdown.ParseWikiLink(ref)
+		p := LinkProblem{Source: n.FilePath, Link: ref}
+
+		target := n
+		if l.Target != "" {
+			t, ok := bc.links.resolve(l.Target, n)
+			if !ok {
+				p.Reason = "no such note"
+				c.broken = append(c.broken, p)
+				continue
+			}
+			target = t
+			p.Target = t.FilePath
+			_, published := bc.slugIndex[t.Slug]
+			c.targets = append(c.targets, checkedTarget{file: t.FilePath, hash: t.Hash, published: published})
+			if !published {
+				p.Reason = "note is not published"
+				c.unpublished = append(c.unpublished, p)
+				continue
+			}
+		}
+
+		a := target.parsed.anchors
+		switch {
+		case l.Heading != "" && !a.headings[markdown.HeadingID(l.Heading)]:
+			p.Reason = "no such heading"
+			c.broken = append(c.broken, p)
+		case l.Block != "" && !a.blocks[l.Block]:
+			p.Reason = "no such block"
+			c.broken = append(c.broken, p)
+		}
+	}
+
+	for _, img := range n.parsed.images {
+		if !b.imageExists(n, img) {
+			c.missing = append(c.missing, ImageProblem{Source: n.FilePath, Image: img})
+		}
+	}
+	return c
+}
+
+// current reports whether c still holds for n: neither n nor the notes it
+// links to changed, and no note or image was added, removed or renamed.
+func (c noteCheck) current(n Note, bc *buildContext, imageGen int) bool {
+	if c.hash != n.Hash || c.links != bc.links.key || c.images != imageGen {
+		return false
+	}
+	for _, t := range c.targets {
+		target, ok := bc.links.byFile[t.file]
+		if !ok || target.Hash != t.hash {
+			return false
+		}
+		if _, published := bc.slugIndex[target.Slug]; published != t.published {
+			return false
+		}
+	}
+	return true
+}
+
+// wikiRefs returns the text inside the wikilinks and note embeds in body.
+func wikiRefs(body string) []string {
+	var refs []string
+	for _, m := range markdown.WikilinkRe.FindAllStringSubmatch(markdown.ObsidianEmbedRe.ReplaceAllString(body, ""), -1) {
+		refs = append(refs, m[1])
+	}
+	for _, m := range markdown.ObsidianEmbedRe.FindAllStringSubmatch(body, -1) {
+		if markdown.IsNoteEmbed(m[1]) {
+			refs = append(refs, m[1])
+		}
+	}
+	return refs
+}
+
+// imageExists reports whether an image reference in n resolves to an image
+// in the vault. External URLs are assumed to exist.
+func (b *Builder) imageExists(n Note, ref string) bool {
+	if u, err := url.Parse(ref); err == nil && (u.Scheme != "" || u.Host != "") {
+		return true
+	}
+	_, ok := b.resolveImage(n, ref)
+	return ok
+}
+
+type noteAnchors struct {
+	headings map[string]bool // heading ids
+	blocks   map[string]bool // block ids, without '^'
+}
+
+var atxHeadingRe = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)(\s+#+)?\s*$`)
+
+// findAnchors collects the heading and block ids a note's body defines,
+// skipping code.
+func findAnchors(body string) noteAnchors {
+	a := noteAnchors{headings: make(map[string]bool), blocks: make(map[string]bool)}
+	masked := strings.Split(markdown.MaskCode(body), "\n")
+	for i, line := range strings.Split(body, "\n") {
+		if m := atxHeadingRe.FindStringSubmatch(line); m != nil && atxHeadingRe.MatchString(masked[i]) {
+			a.headings[markdown.HeadingID(wikiLinksToText(m[1]))] = true
+		}
+		if m := markdown.BlockIDRe.FindStringSubmatch(masked[i]); m != nil {
+			a.blocks[m[2]] = true
+		}
+	}
+	return a
+}
+
+// saveReport keeps r as the latest report and writes it next to the build
+// directories as report.json and report.html, outside the published site.
+func (b *Builder) saveReport(r *Report) error {
+	b.reportMu.Lock()
+	b.report, b.reportTmpl = r, b.tmpl
+	b.reportMu.Unlock()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(b.outDir, "report.json"), data); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := b.tmpl.ExecuteTemplate(&buf, "report.html", r); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(b.outDir, "report.html"), buf.Bytes())
+}
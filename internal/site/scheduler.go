@@ -0,0 +1,145 @@
+package site
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// BuildStatus describes the scheduler's current state and its most recent build.
+type BuildStatus struct {
+	Running      bool      `json:"running"`
+	Pending      bool      `json:"pending"`
+	Builds       int       `json:"builds"`
+	LastStart    time.Time `json:"last_start,omitzero"`
+	LastDuration string    `json:"last_duration,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	NextChange   time.Time `json:"next_change,omitzero"` // when a scheduled note goes up or down
+}
+
+// Scheduler runs site builds in the background. Triggers arriving in a burst
+// are coalesced into a single build that starts once no trigger has arrived
+// for the quiet period, or once maxDelay has passed since the first trigger
+// of the burst. Builds never run concurrently; triggers that arrive during a
+// build schedule exactly one follow-up build. The scheduler also triggers a
+// build when a note's publish_at or unpublish_at time comes.
+type Scheduler struct {
+	builder  *Builder
+	quiet    time.Duration
+	maxDelay time.Duration
+	trigger  chan struct{}
+
+	mu     sync.Mutex
+	status BuildStatus
+	wake   *time.Timer // triggers the build for the next scheduled note
+}
+
+func NewScheduler(builder *Builder, quiet, maxDelay time.Duration) *Scheduler {
+	if maxDelay < quiet {
+		maxDelay = quiet
+	}
+	return &Scheduler{
+		builder:  builder,
+		quiet:    quiet,
+		maxDelay: maxDelay,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Start launches the background build loop.
+func (s *Scheduler) Start() {
+	s.scheduleWake()
+	go s.loop()
+}
+
+// Trigger requests a rebuild. It never blocks.
+func (s *Scheduler) Trigger() {
+	s.mu.Lock()
+	s.status.Pending = true
+	s.mu.Unlock()
+
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// A trigger is already queued; it will cover this one.
+	}
+}
+
+// Status returns a snapshot of the scheduler state.
+func (s *Scheduler) Status() BuildStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *Scheduler) loop() {
+	for range s.trigger {
+		s.waitForQuiet()
+		s.build()
+	}
+}
+
+// waitForQuiet absorbs further triggers until the burst settles.
+func (s *Scheduler) waitForQuiet() {
+	deadline := time.NewTimer(s.maxDelay)
+	defer deadline.Stop()
+	quiet := time.NewTimer(s.quiet)
+	defer quiet.Stop()
+
+	for {
+		select {
+		case <-s.trigger:
+			quiet.Reset(s.quiet)
+		case <-quiet.C:
+			return
+		case <-deadline.C:
+			return
+		}
+	}
+}
+
+func (s *Scheduler) build() {
+	start := time.Now()
+	s.mu.Lock()
+	s.status.Running = true
+	s.status.Pending = false
+	s.status.LastStart = start
+	s.mu.Unlock()
+
+	err := s.builder.Build()
+	elapsed := time.Since(start)
+
+	s.mu.Lock()
+	s.status.Running = false
+	s.status.Builds++
+	s.status.LastDuration = elapsed.Round(time.Millisecond).String()
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("site build error: %v", err)
+	} else {
+		log.Printf("site rebuilt in %s", elapsed.Round(time.Millisecond))
+	}
+	s.scheduleWake()
+}
+
+// scheduleWake sets up a build for when the next scheduled note goes up or
+// comes down, replacing the previous one.
+func (s *Scheduler) scheduleWake() {
+	next := s.builder.NextChange()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wake != nil {
+		s.wake.Stop()
+		s.wake = nil
+	}
+	s.status.NextChange = next
+	if !next.IsZero() {
+		s.wake = time.AfterFunc(time.Until(next), s.Trigger)
+	}
+}
@@ -0,0 +1,88 @@
+package site
+
+import (
+	"encoding/xml"
+	"io"
+	"path"
+	"time"
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// buildSitemap writes sitemap.xml listing the note pages, except those marked
+// noindex or protected, and the listings. Like the feeds, it needs the base URL.
+func (b *Builder) buildSitemap(bc *buildContext) error {
+	if b.config.BaseURL == "" {
+		return nil
+	}
+
+	var urls []sitemapURL
+	add := func(rel string, notes []Note) {
+		var lastMod time.Time
+		for _, n := range notes {
+			if t := n.lastModified(); t.After(lastMod) {
+				lastMod = t
+			}
+		}
+		u := sitemapURL{Loc: b.config.canonicalURL(rel)}
+		if !lastMod.IsZero() {
+			u.LastMod = lastMod.UTC().Format("2006-01-02")
+		}
+		urls = append(urls, u)
+	}
+
+	if home, ok := bc.slugIndex["home"]; ok {
+		if !home.NoIndex && !home.Protected() {
+			add("index.html", []Note{home})
+		}
+		add(path.Join("index", "index.html"), bc.published)
+	} else {
+		add("index.html", bc.published)
+	}
+	for _, n := range bc.published {
+		if !n.NoIndex && !n.Protected() {
+			add(path.Join(n.Slug, "index.html"), []Note{n})
+		}
+	}
+	if len(bc.tags) > 0 {
+		add(path.Join("tags", "index.html"), bc.published)
+		for _, g := range bc.tags {
+			add(path.Join("tags", g.Slug, "index.html"), g.Notes)
+		}
+	}
+	for _, g := range bc.groups {
+		if g.Slug != "" {
+			add(path.Join("groups", g.Slug, "index.html"), g.Notes)
+		}
+	}
+
+	set := sitemapURLSet{NS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: urls}
+	return b.outputs.emit("sitemap.xml", hashKey("sitemap", set), func(w io.Writer) error {
+		return writeXML(w, set)
+	})
+}
+
+// buildRobots writes robots.txt: Config.Robots if set, otherwise rules
+// allowing everything and pointing at the sitemap.
+func (b *Builder) buildRobots() error {
+	data := []byte(b.config.Robots)
+	if len(data) == 0 {
+		data = []byte("User-agent: *\nAllow: /\n")
+		if b.config.BaseURL != "" {
+			data = append(data, "\nSitemap: "+b.config.absURL("/sitemap.xml")+"\n"...)
+		}
+	}
+	return b.outputs.emit("robots.txt", hashKey(data), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
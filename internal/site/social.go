@@ -0,0 +1,141 @@
+package site
+
+import (
+	"image"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nilszeilon/notesync/internal/imaging"
+	"github.com/nilszeilon/notesync/internal/markdown"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// descriptionLength is the length, in characters, that descriptions taken
+// from a note's first paragraph are cut to.
+const descriptionLength = 200
+
+// cardBackgroundFile is the image in the vault's templates folder that social
+// cards are drawn over, replacing the default background.
+const cardBackgroundFile = "card.png"
+
+// noteImageRefs returns the images n shows: those embedded in its body and
+// its cover image.
+func noteImageRefs(n Note) []string {
+	refs := markdown.ExtractImagePaths(n.Body)
+	if cover := n.CoverImage(); cover != "" && !isExternalURL(cover) {
+		refs = append(refs, cover)
+	}
+	return refs
+}
+
+func isExternalURL(ref string) bool {
+	u, err := url.Parse(ref)
+	return err == nil && (u.Scheme != "" || u.Host != "")
+}
+
+// description returns n's description frontmatter, or else the text of its
+// first paragraph, shortened to about descriptionLength characters.
+func (b *Builder) description(n Note) string {
+	if n.Description != "" {
+		return strings.TrimSpace(n.Description)
+	}
+	source := []byte(wikiLinksToText(n.Body))
+	doc := b.markdown(n).Parser().Parse(text.NewReader(source))
+	for c := doc.FirstChild(); c != nil; c = c.NextSibling() {
+		if _, ok := c.(*ast.Paragraph); !ok {
+			continue
+		}
+		if s := strings.Join(strings.Fields(inlineText(c, source)), " "); s != "" {
+			return truncateText(s, descriptionLength)
+		}
+	}
+	return ""
+}
+
+// truncateText cuts s to at most max characters at a word boundary, adding
+// an ellipsis if anything was cut.
+func truncateText(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	cut := string([]rune(s)[:max])
+	if i := strings.LastIndexByte(cut, ' '); i > max/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:.-–—") + "…"
+}
+
+// previewImage returns the absolute URL of the image shown in link previews
+// of the page at rel: n's cover image, or the card generated for it. It is
+// empty without a base URL, since previews need absolute URLs.
+func (b *Builder) previewImage(rel string, n Note, bc *buildContext) string {
+	if b.config.BaseURL == "" {
+		return ""
+	}
+	if cover, ok := b.cover(n, bc); ok {
+		return cover
+	}
+	return b.config.absURL(cardPath(rel))
+}
+
+// cover returns the URL of n's cover image, if it has one that exists.
+func (b *Builder) cover(n Note, bc *buildContext) (string, bool) {
+	ref := n.CoverImage()
+	if ref == "" {
+		return "", false
+	}
+	if isExternalURL(ref) {
+		return ref, true
+	}
+	if img, ok := b.resolveImage(n, ref); ok {
+		return b.config.absURL(bc.imageURL(img, img)), true
+	}
+	return "", false
+}
+
+// cardPath returns the path of the social card of the page at rel, next to
+// the page.
+func cardPath(rel string) string {
+	return path.Join(path.Dir("/"+filepath.ToSlash(rel)), "card.png")
+}
+
+// buildCard writes the social card for the page at rel, unless n has a
+// cover image to show instead. Cards need a base URL to be linked to.
+func (b *Builder) buildCard(rel string, n Note, bc *buildContext) error {
+	if b.config.BaseURL == "" {
+		return nil
+	}
+	if _, ok := b.cover(n, bc); ok {
+		return nil
+	}
+	key := hashKey("card", n.Title, b.config.Title, b.cardKey)
+	return b.outputs.emit(strings.TrimPrefix(cardPath(rel), "/"), key, func(w io.Writer) error {
+		card, err := imaging.Card(b.cardBG, n.Title, b.config.Title)
+		if err != nil {
+			return err
+		}
+		return imaging.Encode(w, card, "png")
+	})
+}
+
+// loadCardBackground reads the social card background from the vault's
+// templates folder, if there is one.
+func loadCardBackground(dataDir string) (image.Image, string) {
+	data, err := os.ReadFile(filepath.Join(dataDir, "templates", cardBackgroundFile))
+	if err != nil {
+		return nil, ""
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
+		log.Printf("templates/%s: %v (using default)", cardBackgroundFile, err)
+		return nil, ""
+	}
+	return img, hashKey(data)
+}
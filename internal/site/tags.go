@@ -0,0 +1,86 @@
+package site
+
+import (
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nilszeilon/notesync/internal/markdown"
+)
+
+// tagGroup is a tag together with the published notes carrying it.
+type tagGroup struct {
+	Name  string
+	Slug  string
+	Notes []Note
+}
+
+// collectTags groups notes by tag, sorted by tag name. Notes keep the order
+// they're passed in. Tags that differ only in case are merged.
+func collectTags(notes []Note) []tagGroup {
+	bySlug := make(map[string]*tagGroup)
+	var groups []*tagGroup
+	for _, n := range notes {
+		for _, t := range n.Tags {
+			slug := markdown.TagSlug(t)
+			if strings.Trim(slug, "/") == "" {
+				continue
+			}
+			g, ok := bySlug[slug]
+			if !ok {
+				g = &tagGroup{Name: t, Slug: slug}
+				bySlug[slug] = g
+				groups = append(groups, g)
+			}
+			g.Notes = append(g.Notes, n)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i].Name) < strings.ToLower(groups[j].Name)
+	})
+	out := make([]tagGroup, len(groups))
+	for i, g := range groups {
+		out[i] = *g
+	}
+	return out
+}
+
+func tagSummaries(tags []string) []TagSummary {
+	var out []TagSummary
+	for _, t := range tags {
+		slug := markdown.TagSlug(t)
+		if strings.Trim(slug, "/") == "" {
+			continue
+		}
+		out = append(out, TagSummary{Name: t, Slug: slug})
+	}
+	return out
+}
+
+// buildTagPages writes the /tags/ overview and a listing per tag at
+// /tags/{tag}/.
+func (b *Builder) buildTagPages(bc *buildContext) error {
+	if len(bc.tags) == 0 {
+		return nil
+	}
+
+	rel := path.Join("tags", "index.html")
+	data := TagsData{Site: b.siteData(), Canonical: b.config.canonicalURL(rel)}
+	for _, g := range bc.tags {
+		data.Tags = append(data.Tags, TagSummary{Name: g.Name, Slug: g.Slug, Count: len(g.Notes)})
+	}
+	err := b.outputs.emit(rel, hashKey("tags", data), func(w io.Writer) error {
+		return b.tmpl.ExecuteTemplate(w, "tags.html", data)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, g := range bc.tags {
+		if err := b.buildListing(g.Notes, path.Join("tags", g.Slug), "#"+g.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
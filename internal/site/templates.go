@@ -3,6 +3,7 @@ package site
 import (
 	"html/template"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 )
@@ -10,44 +11,60 @@ import (
 var DefaultTemplates *template.Template
 var DefaultStyleCSS []byte
 
+// baseTemplates is a copy of DefaultTemplates that is never executed, so user
+// overrides can always be added to a clone of it. Templates can't be cloned
+// once they have been executed.
+var baseTemplates *template.Template
+
 func LoadTemplates(fsys fs.FS) error {
 	var err error
 	DefaultTemplates, err = template.ParseFS(fsys, "*.html")
 	if err != nil {
 		return err
 	}
+	baseTemplates, err = DefaultTemplates.Clone()
+	if err != nil {
+		return err
+	}
 
 	DefaultStyleCSS, err = fs.ReadFile(fsys, "style.css")
 	return err
 }
 
 // loadUserTemplates checks {dataDir}/templates/ for user overrides.
-// Returns templates and CSS to use for this build.
+// Returns templates and CSS to use for this build. Each *.html file there
+// replaces the built-in template of the same name; templates without an
+// override keep the default.
 func loadUserTemplates(dataDir string) (*template.Template, []byte) {
 	tmplDir := filepath.Join(dataDir, "templates")
 
 	tmpl := DefaultTemplates
 	css := DefaultStyleCSS
 
-	// Try loading user HTML templates
-	if page, err := os.ReadFile(filepath.Join(tmplDir, "page.html")); err == nil {
-		if idx, err := os.ReadFile(filepath.Join(tmplDir, "index.html")); err == nil {
-			if t, err := template.New("page.html").Parse(string(page)); err == nil {
-				if t, err = t.New("index.html").Parse(string(idx)); err == nil {
-					tmpl = t
-				}
-			}
-		} else {
-			// Only page.html override — parse it, copy index from defaults
-			if t, err := template.Must(DefaultTemplates.Clone()).New("page.html").Parse(string(page)); err == nil {
-				tmpl = t
-			}
+	// Try loading user HTML templates. Each is parsed into a clone, so one
+	// that fails to parse leaves the others intact; the clones are never
+	// executed before the next one is made.
+	base := baseTemplates
+	entries, _ := os.ReadDir(tmplDir)
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".html" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(tmplDir, e.Name()))
+		if err != nil {
+			continue
 		}
-	} else if idx, err := os.ReadFile(filepath.Join(tmplDir, "index.html")); err == nil {
-		// Only index.html override
-		if t, err := template.Must(DefaultTemplates.Clone()).New("index.html").Parse(string(idx)); err == nil {
-			tmpl = t
+		clone, err := base.Clone()
+		if err != nil {
+			log.Printf("template %s: %v (using default)", e.Name(), err)
+			continue
 		}
+		t, err := clone.New(e.Name()).Parse(string(data))
+		if err != nil {
+			log.Printf("template %s: %v (using default)", e.Name(), err)
+			continue
+		}
+		base, tmpl = t, t
 	}
 
 	// Try loading user CSS
@@ -58,10 +75,58 @@ func loadUserTemplates(dataDir string) (*template.Template, []byte) {
 	return tmpl, css
 }
 
+// userTemplatesKey identifies the current state of the user template overrides,
+// so the builder can tell when a full rebuild is needed.
+func userTemplatesKey(dataDir string) string {
+	var stamps []any
+	entries, _ := os.ReadDir(filepath.Join(dataDir, "templates"))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() {
+			continue
+		}
+		stamps = append(stamps, e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return hashKey(stamps...)
+}
+
+// SiteData is the site-wide configuration, available to every template as
+// .Site.
+type SiteData struct {
+	Title    string
+	Author   string
+	Language string // BCP 47 language tag, e.g. "en"
+	BaseURL  string // public URL without a trailing slash; empty if not configured
+}
+
 type IndexData struct {
+	Site      SiteData
+	Canonical string // absolute URL of the page; empty without a base URL
+	Title     string // heading for tag and group listings; empty on the main index
+	Notes     []NoteSummary
+	Groups    []GroupSummary // set on the main index when notes use groups
+}
+
+// GroupSummary is a group and its notes, ordered by order/weight, then date.
+// Notes without a group are collected in a GroupSummary with an empty Name.
+type GroupSummary struct {
+	Name  string
+	Slug  string
 	Notes []NoteSummary
 }
 
+type TagsData struct {
+	Site      SiteData
+	Canonical string
+	Tags      []TagSummary
+}
+
+type TagSummary struct {
+	Name  string
+	Slug  string
+	Count int
+}
+
 type NoteSummary struct {
 	Title   string
 	Slug    string
@@ -69,8 +134,26 @@ type NoteSummary struct {
 }
 
 type PageData struct {
-	Title     string
-	DateStr   string
-	Content   template.HTML
-	Backlinks []NoteSummary
+	Site        SiteData
+	Canonical   string
+	NoIndex     bool   // asks search engines not to index the page
+	Description string // description frontmatter or the start of the first paragraph
+	Image       string // absolute URL of the link preview image; empty without a base URL
+	Slug        string
+	Title       string
+	DateStr     string
+	Content     template.HTML
+	Tags        []TagSummary
+	Group       *GroupSummary // the note's group and its siblings, if any
+	Backlinks   []NoteSummary
+	TOC         []TOCEntry // the note's headings, nested; empty when it has no table of contents
+}
+
+// TOCEntry is a heading in a note's table of contents. ID is the heading's
+// anchor, so "#" + ID links to it; Children are the headings under it.
+type TOCEntry struct {
+	Level    int
+	ID       string
+	Text     string
+	Children []TOCEntry
 }
@@ -2,54 +2,95 @@ package site
 
 import (
 	"html"
+	"regexp"
 	"strings"
 
 	"github.com/nilszeilon/notesync/internal/markdown"
 )
 
-// ReplaceWikiLinks converts [[wiki-links]] to HTML anchor tags
-// and ![[image]] embeds to <img> tags.
-func ReplaceWikiLinks(content string) string {
-	// First, replace image embeds ![[image.png]]
-	content = markdown.ObsidianEmbedRe.ReplaceAllStringFunc(content, func(match string) string {
+// ReplaceWikiLinks converts [[wiki-links]] to HTML anchor tags and ![[image]]
+// embeds to <img> tags, leaving code alone. resolve returns the URL a link points to, or false
+// if its target is missing or unpublished, in which case the link is rendered
+// as a span with class wikilink-unresolved. image returns the URL of an
+// embedded image. Note embeds (![[note]]) are replaced with whatever embed
+// returns for the matched text.
+func ReplaceWikiLinks(content string, resolve func(markdown.WikiLink) (string, bool), image func(ref string) string, embed func(match string) string) string {
+	masked := markdown.MaskCode(content)
+
+	// First, replace embeds ![[image.png]] and ![[note]]
+	content, masked = replaceOutsideCode(content, masked, markdown.ObsidianEmbedRe, func(match string) string {
 		inner := strings.TrimSpace(match[3 : len(match)-2]) // strip ![[  ]]
+		if markdown.IsNoteEmbed(inner) {
+			return embed(match)
+		}
 
-		// Handle ![[alt|image.png]] syntax
-		alt := ""
-		path := inner
-		if idx := strings.Index(inner, "|"); idx != -1 {
-			alt = strings.TrimSpace(inner[:idx])
-			path = strings.TrimSpace(inner[idx+1:])
+		// ![[image.png|alt]], ![[image.png|300]] (a width) or ![[alt|image.png]]
+		path, label := markdown.ParseImageEmbed(inner)
+		attrs := ""
+		if m := imageSizeRe.FindStringSubmatch(label); m != nil {
+			attrs = ` width="` + m[1] + `"`
+			if m[2] != "" {
+				attrs += ` height="` + m[2] + `"`
+			}
+			label = ""
 		}
-		if alt == "" {
-			alt = path
+		if label == "" {
+			label = path
 		}
 
-		return `<img src="/images/` + html.EscapeString(path) + `" alt="` + html.EscapeString(alt) + `">`
+		return `<img src="` + html.EscapeString(image(path)) + `" alt="` + html.EscapeString(label) + `"` + attrs + `>`
 	})
 
 	// Then, replace note wikilinks [[link]]
-	content = markdown.WikilinkRe.ReplaceAllStringFunc(content, func(match string) string {
-		inner := match[2 : len(match)-2]
-		display := inner
-		target := inner
-
-		// [[target|display]] — Obsidian convention
-		if idx := strings.Index(inner, "|"); idx != -1 {
-			target = inner[:idx]
-			display = inner[idx+1:]
-		}
-
-		display = strings.TrimSpace(display)
-		target = strings.TrimSpace(target)
-		slug := markdown.Slugify(target)
-
-		href := "/" + slug
-		if slug == "index" {
-			href = "/"
-		}
-		return `<a href="` + href + `">` + html.EscapeString(display) + `</a>`
+	content, masked = replaceOutsideCode(content, masked, markdown.WikilinkRe, func(match string) string {
+		l := markdown.ParseWikiLink(match[2 : len(match)-2])
+		href, ok := resolve(l)
+		return wikiLinkHTML(l, href, ok, "")
 	})
 
+	// Finally, turn block IDs (" ^block-id") into anchors for [[note#^block-id]]
+	content, _ = replaceOutsideCode(content, masked, markdown.BlockIDRe, func(match string) string {
+		return markdown.BlockIDRe.ReplaceAllString(match, `$1<span id="^$2"></span>`)
+	})
 	return content
 }
+
+// replaceOutsideCode replaces the matches of re in content with what repl
+// returns for them, like re.ReplaceAllStringFunc, but looks for them in
+// masked, content with its code blanked out by markdown.MaskCode. It returns
+// the new content and masked, which still line up.
+func replaceOutsideCode(content, masked string, re *regexp.Regexp, repl func(match string) string) (string, string) {
+	matches := re.FindAllStringIndex(masked, -1)
+	if matches == nil {
+		return content, masked
+	}
+	var sb, sm strings.Builder
+	last := 0
+	for _, m := range matches {
+		r := repl(content[m[0]:m[1]])
+		sb.WriteString(content[last:m[0]])
+		sb.WriteString(r)
+		sm.WriteString(masked[last:m[0]])
+		sm.WriteString(r)
+		last = m[1]
+	}
+	sb.WriteString(content[last:])
+	sm.WriteString(masked[last:])
+	return sb.String(), sm.String()
+}
+
+// imageSizeRe matches the size in ![[image.png|300]] or ![[image.png|300x200]].
+var imageSizeRe = regexp.MustCompile(`^(\d+)(?:x(\d+))?$`)
+
+// wikiLinkHTML renders a resolved link as an anchor with the given class, or
+// an unresolved one as a span.
+func wikiLinkHTML(l markdown.WikiLink, href string, ok bool, class string) string {
+	if !ok {
+		return `<span class="wikilink-unresolved">` + html.EscapeString(l.Text()) + `</span>`
+	}
+	attrs := `href="` + html.EscapeString(href) + `"`
+	if class != "" {
+		attrs += ` class="` + class + `"`
+	}
+	return `<a ` + attrs + `>` + html.EscapeString(l.Text()) + `</a>`
+}
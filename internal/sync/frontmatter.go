@@ -8,25 +8,67 @@ import (
 	"github.com/nilszeilon/notesync/internal/markdown"
 )
 
-// collectPublishedImageRefs walks dir and returns the set of image basenames
-// referenced by published markdown files.
-func collectPublishedImageRefs(dir string) map[string]bool {
-	refs := make(map[string]bool)
+// collectPublishedImages walks dir and returns the set of images embedded by
+// published markdown files, as slash-separated paths relative to dir.
+func collectPublishedImages(dir, attachments string) map[string]bool {
+	var notes, images []string
 	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() || !fileutil.IsMd(path) {
+		if err != nil || info.IsDir() {
 			return nil
 		}
-		if !markdown.IsPublished(path) {
-			return nil
+		relPath, _ := filepath.Rel(dir, path)
+		switch {
+		case fileutil.IsImage(path):
+			images = append(images, relPath)
+		case fileutil.IsMd(path) && markdown.IsPublished(path):
+			notes = append(notes, relPath)
 		}
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil
-		}
-		for _, img := range markdown.ExtractImageRefs(string(data)) {
+		return nil
+	})
+
+	index := markdown.NewImageIndex(images, attachments)
+	refs := make(map[string]bool)
+	for _, relPath := range notes {
+		for _, img := range noteImages(dir, relPath, index) {
 			refs[img] = true
 		}
+	}
+	return refs
+}
+
+// noteImages returns the images embedded by the markdown file at relPath,
+// and its cover image, that exist in index, once each.
+func noteImages(dir, relPath string, index *markdown.ImageIndex) []string {
+	data, err := os.ReadFile(filepath.Join(dir, relPath))
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var images []string
+	fm, _ := markdown.ParseFrontmatter(string(data))
+	refs := markdown.ExtractImagePaths(string(data))
+	if cover := fm.CoverImage(); cover != "" {
+		refs = append(refs, cover)
+	}
+	for _, ref := range refs {
+		if img, ok := index.Resolve(relPath, ref); ok && !seen[img] {
+			seen[img] = true
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
+// vaultImages indexes the images under dir.
+func vaultImages(dir, attachments string) *markdown.ImageIndex {
+	var images []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !fileutil.IsImage(path) {
+			return nil
+		}
+		relPath, _ := filepath.Rel(dir, path)
+		images = append(images, relPath)
 		return nil
 	})
-	return refs
+	return markdown.NewImageIndex(images, attachments)
 }
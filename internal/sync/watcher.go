@@ -20,10 +20,11 @@ type Watcher struct {
 	publishClient *Client
 	pushOnly      bool
 	pollInterval  time.Duration
+	attachments   string // Obsidian attachment folder, for resolving image embeds
 }
 
-func NewWatcher(dir string, client *Client, publishClient *Client, pushOnly bool, pollInterval time.Duration) *Watcher {
-	return &Watcher{dir: dir, client: client, publishClient: publishClient, pushOnly: pushOnly, pollInterval: pollInterval}
+func NewWatcher(dir string, client *Client, publishClient *Client, pushOnly bool, pollInterval time.Duration, attachments string) *Watcher {
+	return &Watcher{dir: dir, client: client, publishClient: publishClient, pushOnly: pushOnly, pollInterval: pollInterval, attachments: attachments}
 }
 
 // FullSync compares local files with remote and uploads diffs.
@@ -37,13 +38,13 @@ func (w *Watcher) FullSync() error {
 
 	// Sync published files + referenced images to publish client
 	if w.publishClient != nil {
-		referencedImages := collectPublishedImageRefs(w.dir)
+		referencedImages := collectPublishedImages(w.dir, w.attachments)
 		shouldSync := func(relPath, absPath string) bool {
 			if fileutil.IsMd(relPath) {
 				return markdown.IsPublished(absPath)
 			}
 			if fileutil.IsImage(relPath) {
-				return referencedImages[filepath.Base(relPath)]
+				return referencedImages[filepath.ToSlash(relPath)]
 			}
 			if isTemplateFile(relPath) {
 				return true
@@ -339,7 +340,7 @@ func (w *Watcher) handleWrite(relPath, absPath string) {
 				log.Printf("publish upload error: %v", err)
 			}
 			// Also sync any images referenced by this published file
-			w.syncReferencedImages(absPath)
+			w.syncReferencedImages(relPath)
 		} else if fileutil.IsMd(relPath) {
 			// Markdown file that is not published — remove from publish server
 			log.Printf("removing unpublished from publish server: %s", relPath)
@@ -348,8 +349,8 @@ func (w *Watcher) handleWrite(relPath, absPath string) {
 			}
 		} else if fileutil.IsImage(relPath) {
 			// Image changed — upload only if referenced by any published file
-			refs := collectPublishedImageRefs(w.dir)
-			if refs[filepath.Base(relPath)] {
+			refs := collectPublishedImages(w.dir, w.attachments)
+			if refs[filepath.ToSlash(relPath)] {
 				log.Printf("syncing (publish, referenced image): %s", relPath)
 				if err := w.publishClient.Upload(relPath, absPath); err != nil {
 					log.Printf("publish upload error: %v", err)
@@ -364,36 +365,15 @@ func (w *Watcher) handleWrite(relPath, absPath string) {
 	}
 }
 
-// syncReferencedImages reads a published markdown file, finds its image
-// references, and uploads any matching local images to the publish server.
-func (w *Watcher) syncReferencedImages(absPath string) {
-	data, err := os.ReadFile(absPath)
-	if err != nil {
-		return
-	}
-	refs := markdown.ExtractImageRefs(string(data))
-	if len(refs) == 0 {
-		return
-	}
-	refSet := make(map[string]bool, len(refs))
-	for _, r := range refs {
-		refSet[r] = true
-	}
-	// Walk the sync dir for matching images
-	filepath.Walk(w.dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() || !fileutil.IsImage(path) {
-			return nil
-		}
-		if !refSet[filepath.Base(path)] {
-			return nil
-		}
-		relPath, _ := filepath.Rel(w.dir, path)
-		log.Printf("syncing (publish, image for published note): %s", relPath)
-		if err := w.publishClient.Upload(relPath, path); err != nil {
+// syncReferencedImages reads a published markdown file, resolves its image
+// references against the vault and uploads the images to the publish server.
+func (w *Watcher) syncReferencedImages(relPath string) {
+	for _, img := range noteImages(w.dir, relPath, vaultImages(w.dir, w.attachments)) {
+		log.Printf("syncing (publish, image for published note): %s", img)
+		if err := w.publishClient.Upload(img, filepath.Join(w.dir, filepath.FromSlash(img))); err != nil {
 			log.Printf("publish image upload error: %v", err)
 		}
-		return nil
-	})
+	}
 }
 
 func (w *Watcher) handleDirDelete(relPrefix string) {